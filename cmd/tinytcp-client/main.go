@@ -1,45 +1,157 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"net"
+	"time"
 
-	"github.com/sasakihasuto/tinytcp/internal/socket"
+	"github.com/sasakihasuto/tinytcp/internal/ip"
+	"github.com/sasakihasuto/tinytcp/internal/link"
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+	"github.com/sasakihasuto/tinytcp/internal/tcp"
 )
 
+// segment is a TCP segment received from the tunnel
+type segment struct {
+	header *packet.TCPHeader
+	data   []byte
+}
+
 func main() {
+	serverAddr := flag.String("connect", "127.0.0.1:8080", "TinyTCP address of the server")
+	localAddr := flag.String("local", "127.0.0.1:40000", "TinyTCP address of the client")
+	linkAddr := flag.String("link", "127.0.0.1:9001", "UDP address of the tunnel")
+	peerAddr := flag.String("peer", "127.0.0.1:9000", "UDP address of the server's tunnel")
+	flag.Parse()
+
 	fmt.Println("TinyTCP Client - TCP/IP Stack Implementation")
 	fmt.Println("Starting client...")
-	
-	// Create a new socket
-	clientSocket := socket.NewSocket()
-	
-	// Connect to server
-	serverAddr := "127.0.0.1:8080"
-	err := clientSocket.Connect(serverAddr)
-	if err != nil {
-		log.Fatalf("Failed to connect to %s: %v", serverAddr, err)
-	}
-	
-	fmt.Printf("Connected to %s\n", serverAddr)
-	fmt.Printf("Client state: %s\n", clientSocket.State())
-	fmt.Printf("Remote address: %s\n", clientSocket.RemoteAddr())
-	
+
+	local, err := net.ResolveTCPAddr("tcp", *localAddr)
+	if err != nil {
+		log.Fatalf("Invalid address %s: %v", *localAddr, err)
+	}
+	remote, err := net.ResolveTCPAddr("tcp", *serverAddr)
+	if err != nil {
+		log.Fatalf("Invalid address %s: %v", *serverAddr, err)
+	}
+
+	ep, err := link.NewUDPEndpoint(*linkAddr, *peerAddr, link.DefaultUDPMTU)
+	if err != nil {
+		log.Fatalf("Failed to open UDP link on %s: %v", *linkAddr, err)
+	}
+	defer ep.Close()
+	segments := receiveSegments(ep)
+
+	tcb := tcp.NewTCB(local, remote)
+
+	// 3ウェイハンドシェイク
+	handshake := tcp.NewThreeWayHandshake(tcb)
+	syn, err := handshake.StartClient()
+	if err != nil {
+		log.Fatalf("Failed to start handshake: %v", err)
+	}
+	send(ep, tcb, syn, nil)
+
+	ack, err := handshake.HandleSynAck(waitFor(segments, packet.FlagSYN|packet.FlagACK).header)
+	if err != nil {
+		log.Fatalf("Failed to connect to %s: %v", remote, err)
+	}
+	send(ep, tcb, ack, nil)
+
+	fmt.Printf("Connected to %s\n", remote)
+	fmt.Printf("Client state: %s\n", tcb.State)
+	fmt.Printf("Remote address: %s\n", tcb.RemoteAddr)
+
 	// Send test data
+	dt := tcp.NewDataTransfer(tcb)
 	testMessage := []byte("Hello from TinyTCP client!")
-	n, err := clientSocket.Send(testMessage)
+	dataHeader, err := dt.Send(testMessage)
 	if err != nil {
 		log.Fatalf("Failed to send data: %v", err)
 	}
-	fmt.Printf("Sent %d bytes: %s\n", n, string(testMessage))
-	
-	// For now, just demonstrate that the socket is working
-	fmt.Println("Client prototype working successfully!")
-	
-	// Clean shutdown
-	err = clientSocket.Close()
+	send(ep, tcb, dataHeader, testMessage)
+	if err := dt.ReceiveAck(waitFor(segments, packet.FlagACK).header); err != nil {
+		log.Fatalf("Failed to process ACK: %v", err)
+	}
+	fmt.Printf("Sent %d bytes: %s\n", len(testMessage), string(testMessage))
+
+	// アクティブクローズ
+	closer := tcp.NewFourWayHandshake(tcb)
+	fin, err := closer.Close()
+	if err != nil {
+		log.Fatalf("Failed to close: %v", err)
+	}
+	send(ep, tcb, fin, nil)
+	if err := closer.HandleFinAck(waitFor(segments, packet.FlagACK).header); err != nil {
+		log.Fatalf("Failed to handle FIN ACK: %v", err)
+	}
+	finAck, err := closer.HandleFin(waitFor(segments, packet.FlagFIN).header)
 	if err != nil {
-		log.Printf("Error closing socket: %v", err)
+		log.Fatalf("Failed to handle FIN: %v", err)
 	}
+	send(ep, tcb, finAck, nil)
+
+	fmt.Printf("Client state: %s\n", tcb.State)
 	fmt.Println("Client stopped.")
 }
+
+// receiveSegments decodes IP/TCP packets from the link into a channel
+func receiveSegments(ep link.LinkEndpoint) <-chan segment {
+	segments := make(chan segment, 16)
+	demux := ip.NewDemux()
+	demux.Register(packet.ProtocolTCP, func(src, dst net.IP, payload []byte) {
+		h, data, err := packet.Unmarshal(payload)
+		if err != nil {
+			log.Printf("Dropping malformed segment: %v", err)
+			return
+		}
+		if err := packet.VerifySegment(src, dst, payload); err != nil {
+			log.Printf("Dropping corrupted segment: %v", err)
+			return
+		}
+		segments <- segment{header: h, data: append([]byte(nil), data...)}
+	})
+	ep.SetDeliver(func(pkt []byte) {
+		if err := demux.Deliver(pkt); err != nil {
+			log.Printf("Dropping packet: %v", err)
+		}
+	})
+	return segments
+}
+
+// waitFor waits for a segment carrying flag
+func waitFor(segments <-chan segment, flag uint8) segment {
+	for {
+		select {
+		case s := <-segments:
+			if s.header.HasFlag(flag) {
+				return s
+			}
+		case <-time.After(10 * time.Second):
+			log.Fatalf("Timed out waiting for segment")
+		}
+	}
+}
+
+// send wraps a segment into IP packets and writes them to the link
+func send(ep link.LinkEndpoint, tcb *tcp.TCB, header *packet.TCPHeader, data []byte) {
+	if err := header.SetChecksum(tcb.LocalAddr.IP, tcb.RemoteAddr.IP, data); err != nil {
+		log.Fatalf("Failed to compute checksum: %v", err)
+	}
+	b, err := header.Marshal()
+	if err != nil {
+		log.Fatalf("Failed to encode segment: %v", err)
+	}
+	packets, err := ip.Encapsulate(tcb.LocalAddr.IP, tcb.RemoteAddr.IP, packet.ProtocolTCP, append(b, data...), ep.MTU())
+	if err != nil {
+		log.Fatalf("Failed to encapsulate segment: %v", err)
+	}
+	for _, pkt := range packets {
+		if err := ep.WritePacket(pkt); err != nil {
+			log.Fatalf("Failed to write packet: %v", err)
+		}
+	}
+}
@@ -1,38 +1,175 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
+	"time"
 
+	"github.com/sasakihasuto/tinytcp/internal/ip"
+	"github.com/sasakihasuto/tinytcp/internal/link"
+	"github.com/sasakihasuto/tinytcp/internal/packet"
 	"github.com/sasakihasuto/tinytcp/internal/socket"
+	"github.com/sasakihasuto/tinytcp/internal/tcp"
 )
 
+// segment is a TCP segment received from the tunnel
+type segment struct {
+	src, dst net.IP
+	header   *packet.TCPHeader
+	data     []byte
+}
+
 func main() {
+	addr := flag.String("listen", "127.0.0.1:8080", "TinyTCP address to listen on")
+	linkAddr := flag.String("link", "127.0.0.1:9000", "UDP address of the tunnel")
+	tunName := flag.String("tun", "", "Use this Linux TUN interface instead of the UDP tunnel")
+	flag.Parse()
+
 	fmt.Println("TinyTCP Server - TCP/IP Stack Implementation")
 	fmt.Println("Starting server...")
-	
-	// Create a new socket
-	serverSocket := socket.NewSocket()
-	
-	// Listen on port 8080
-	addr := ":8080"
-	err := serverSocket.Listen(addr)
+
+	localAddr, err := net.ResolveTCPAddr("tcp", *addr)
 	if err != nil {
-		log.Fatalf("Failed to listen on %s: %v", addr, err)
-	}
-	
-	fmt.Printf("Server listening on %s\n", addr)
-	fmt.Printf("Server state: %s\n", serverSocket.State())
-	fmt.Printf("Local address: %s\n", serverSocket.LocalAddr())
-	
-	// For now, just demonstrate that the socket is working
-	fmt.Println("Socket created and listening successfully!")
-	fmt.Println("Server prototype ready.")
-	
-	// Clean shutdown
-	err = serverSocket.Close()
+		log.Fatalf("Invalid address %s: %v", *addr, err)
+	}
+
+	ep, err := openLink(*tunName, *linkAddr)
 	if err != nil {
-		log.Printf("Error closing socket: %v", err)
+		log.Fatalf("Failed to open link: %v", err)
+	}
+	defer ep.Close()
+	segments := receiveSegments(ep)
+
+	tcb := tcp.NewTCB(localAddr, nil)
+	tcb.State = socket.StateListen
+
+	fmt.Printf("Server listening on %s\n", localAddr)
+
+	// Step 1: SYN を待って SYN-ACK を返す
+	syn := waitFor(segments, packet.FlagSYN)
+	tcb.LocalAddr = &net.TCPAddr{IP: syn.dst, Port: localAddr.Port}
+	tcb.RemoteAddr = &net.TCPAddr{IP: syn.src, Port: int(syn.header.SourcePort)}
+	handshake := tcp.NewThreeWayHandshake(tcb)
+	synAck, err := handshake.HandleSyn(syn.header)
+	if err != nil {
+		log.Fatalf("Failed to handle SYN: %v", err)
+	}
+	send(ep, tcb, synAck, nil)
+
+	// Step 2: 最終 ACK でコネクション確立
+	if err := handshake.HandleAck(waitFor(segments, packet.FlagACK).header); err != nil {
+		log.Fatalf("Failed to handle ACK: %v", err)
+	}
+	fmt.Printf("Accepted connection from %s\n", tcb.RemoteAddr)
+
+	// Step 3: FIN が来るまでデータを受信する
+	dt := tcp.NewDataTransfer(tcb)
+	closer := tcp.NewFourWayHandshake(tcb)
+	for tcb.State == socket.StateEstablished {
+		s := waitFor(segments, packet.FlagACK)
+		if s.header.HasFlag(packet.FlagFIN) {
+			ack, err := closer.HandleFin(s.header)
+			if err != nil {
+				log.Fatalf("Failed to handle FIN: %v", err)
+			}
+			send(ep, tcb, ack, nil)
+			break
+		}
+		if len(s.data) == 0 {
+			continue
+		}
+		data, ack, err := dt.Receive(s.header, s.data)
+		if err != nil {
+			log.Printf("Dropping segment: %v", err)
+			continue
+		}
+		fmt.Printf("Received %d bytes: %s\n", len(data), string(data))
+		send(ep, tcb, ack, nil)
+	}
+
+	// Step 4: 受動クローズ
+	fin, err := closer.CloseFromCloseWait()
+	if err != nil {
+		log.Fatalf("Failed to close: %v", err)
+	}
+	send(ep, tcb, fin, nil)
+	if err := closer.HandleFinAck(waitFor(segments, packet.FlagACK).header); err != nil {
+		log.Fatalf("Failed to handle FIN ACK: %v", err)
 	}
+
+	fmt.Printf("Server state: %s\n", tcb.State)
 	fmt.Println("Server stopped.")
 }
+
+// openLink opens the TUN interface if a name is given, otherwise the UDP tunnel
+func openLink(tunName, linkAddr string) (link.LinkEndpoint, error) {
+	if tunName != "" {
+		return openTUN(tunName)
+	}
+
+	// UDP トンネルをリンク層として使う（相手は最初のパケットから学習）
+	fmt.Printf("Using UDP link %s\n", linkAddr)
+	return link.NewUDPEndpoint(linkAddr, "", link.DefaultUDPMTU)
+}
+
+// receiveSegments decodes IP/TCP packets from the link into a channel
+func receiveSegments(ep link.LinkEndpoint) <-chan segment {
+	segments := make(chan segment, 16)
+	demux := ip.NewDemux()
+	demux.Register(packet.ProtocolTCP, func(src, dst net.IP, payload []byte) {
+		h, data, err := packet.Unmarshal(payload)
+		if err != nil {
+			log.Printf("Dropping malformed segment: %v", err)
+			return
+		}
+		if err := packet.VerifySegment(src, dst, payload); err != nil {
+			log.Printf("Dropping corrupted segment: %v", err)
+			return
+		}
+		segments <- segment{src: src, dst: dst, header: h, data: append([]byte(nil), data...)}
+	})
+	ep.SetDeliver(func(pkt []byte) {
+		// TUN ではカーネルの ICMPv6 なども届くので TCP 以外は黙って捨てる
+		if err := demux.Deliver(pkt); err != nil && !errors.Is(err, ip.ErrUnknownProtocol) {
+			log.Printf("Dropping packet: %v", err)
+		}
+	})
+	return segments
+}
+
+// waitFor waits for a segment carrying flag
+func waitFor(segments <-chan segment, flag uint8) segment {
+	for {
+		select {
+		case s := <-segments:
+			if s.header.HasFlag(flag) {
+				return s
+			}
+		case <-time.After(30 * time.Second):
+			log.Fatalf("Timed out waiting for segment")
+		}
+	}
+}
+
+// send wraps a segment into IP packets and writes them to the link
+func send(ep link.LinkEndpoint, tcb *tcp.TCB, header *packet.TCPHeader, data []byte) {
+	if err := header.SetChecksum(tcb.LocalAddr.IP, tcb.RemoteAddr.IP, data); err != nil {
+		log.Fatalf("Failed to compute checksum: %v", err)
+	}
+	b, err := header.Marshal()
+	if err != nil {
+		log.Fatalf("Failed to encode segment: %v", err)
+	}
+	packets, err := ip.Encapsulate(tcb.LocalAddr.IP, tcb.RemoteAddr.IP, packet.ProtocolTCP, append(b, data...), ep.MTU())
+	if err != nil {
+		log.Fatalf("Failed to encapsulate segment: %v", err)
+	}
+	for _, pkt := range packets {
+		if err := ep.WritePacket(pkt); err != nil {
+			log.Fatalf("Failed to write packet: %v", err)
+		}
+	}
+}
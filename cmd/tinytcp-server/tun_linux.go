@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sasakihasuto/tinytcp/internal/link"
+)
+
+// openTUN opens the Linux TUN interface name
+func openTUN(name string) (link.LinkEndpoint, error) {
+	ep, err := link.NewTUNEndpoint(name, 1500)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Using TUN interface %s\n", ep.Name())
+	return ep, nil
+}
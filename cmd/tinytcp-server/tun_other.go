@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+
+	"github.com/sasakihasuto/tinytcp/internal/link"
+)
+
+// openTUN fails: TUN interfaces are only supported on Linux
+func openTUN(name string) (link.LinkEndpoint, error) {
+	return nil, errors.New("TUN interfaces are only supported on Linux")
+}
@@ -43,6 +43,11 @@ func main() {
 	fmt.Println("\n=== デモ完了 ===")
 }
 
+// waitForTimeout sleeps until the current retransmission timeout has passed
+func waitForTimeout(tcb *tcp.TCB) {
+	time.Sleep(tcb.RetransmissionTimeout + 100*time.Millisecond)
+}
+
 func testHandshakeRetransmission(tcb *tcp.TCB) {
 	// Start handshake
 	handshake := tcp.NewThreeWayHandshake(tcb)
@@ -57,7 +62,9 @@ func testHandshakeRetransmission(tcb *tcp.TCB) {
 	fmt.Printf("再送キューサイズ: %d\n", tcb.RetransmissionQueue.Size())
 
 	// Simulate timeout and check retransmission
-	time.Sleep(600 * time.Millisecond) // Wait for timeout
+	// タイムアウトのたびにRTOは倍になるので現在値より少し長く待つ
+	fmt.Printf("現在のRTO: %v\n", tcb.RetransmissionTimeout)
+	waitForTimeout(tcb)
 
 	dt := tcp.NewDataTransfer(tcb)
 	timeoutEntries, err := dt.CheckRetransmissions()
@@ -109,7 +116,8 @@ func testDataRetransmission(tcb *tcp.TCB) {
 	fmt.Printf("再送キューサイズ: %d\n", dt.GetRetransmissionQueueSize())
 
 	// Wait for timeout
-	time.Sleep(600 * time.Millisecond)
+	fmt.Printf("現在のRTO: %v\n", tcb.RetransmissionTimeout)
+	waitForTimeout(tcb)
 
 	// Check for retransmissions
 	timeoutEntries, err := dt.CheckRetransmissions()
@@ -156,7 +164,8 @@ func testCloseRetransmission(tcb *tcp.TCB) {
 	fmt.Printf("再送キューサイズ: %d\n", tcb.RetransmissionQueue.Size())
 
 	// Wait for timeout
-	time.Sleep(600 * time.Millisecond)
+	fmt.Printf("現在のRTO: %v\n", tcb.RetransmissionTimeout)
+	waitForTimeout(tcb)
 
 	// Check for retransmissions
 	dt := tcp.NewDataTransfer(tcb)
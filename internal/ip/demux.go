@@ -0,0 +1,93 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Errors returned by the demultiplexer
+var (
+	ErrUnknownProtocol = errors.New("ip: no handler for protocol")
+	ErrFragmented      = errors.New("ip: IPv6 fragments are not supported")
+)
+
+// Handler processes the payload of a received IP datagram
+type Handler func(src, dst net.IP, payload []byte)
+
+// Demux hands the payloads of received datagrams to the upper layer
+// registered for their protocol number
+type Demux struct {
+	mu          sync.RWMutex
+	handlers    map[uint8]Handler
+	reassembler *Reassembler
+}
+
+// NewDemux creates a new demultiplexer
+func NewDemux() *Demux {
+	return &Demux{
+		handlers:    make(map[uint8]Handler),
+		reassembler: NewReassembler(DefaultReassemblyTimeout, DefaultReassemblyMemory),
+	}
+}
+
+// Reassembler returns the IPv4 reassembly buffer used by Deliver
+func (d *Demux) Reassembler() *Reassembler {
+	return d.reassembler
+}
+
+// Register sets the handler for a protocol, replacing any previous one
+func (d *Demux) Register(proto uint8, handler Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[proto] = handler
+}
+
+// Deliver decodes an IPv4 or IPv6 datagram and dispatches its payload
+func (d *Demux) Deliver(datagram []byte) error {
+	if len(datagram) == 0 {
+		return ErrTruncated
+	}
+
+	switch datagram[0] >> 4 {
+	case IPv4Version:
+		h, payload, err := UnmarshalIPv4(datagram)
+		if err != nil {
+			return err
+		}
+		if h.IsFragment() {
+			var done bool
+			h, payload, done, err = d.reassembler.Process(h, payload)
+			if err != nil || !done {
+				return err
+			}
+		}
+		return d.dispatch(h.Protocol, h.Src, h.Dst, payload)
+	case IPv6Version:
+		h, rest, err := UnmarshalIPv6(datagram)
+		if err != nil {
+			return err
+		}
+		proto, payload, err := SkipExtensionHeaders(h.NextHeader, rest)
+		if err != nil {
+			return err
+		}
+		return d.dispatch(proto, h.Src, h.Dst, payload)
+	default:
+		return fmt.Errorf("%w: %d", ErrInvalidVersion, datagram[0]>>4)
+	}
+}
+
+// dispatch calls the handler registered for proto
+func (d *Demux) dispatch(proto uint8, src, dst net.IP, payload []byte) error {
+	d.mu.RLock()
+	handler, ok := d.handlers[proto]
+	d.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%w %d", ErrUnknownProtocol, proto)
+	}
+	handler(src, dst, payload)
+	return nil
+}
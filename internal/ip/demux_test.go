@@ -0,0 +1,76 @@
+package ip
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+)
+
+func TestDemuxDeliversTCP(t *testing.T) {
+	src := net.IPv4(10, 0, 0, 1)
+	dst := net.IPv4(10, 0, 0, 2)
+
+	// TCPセグメントをIPデータグラムに包む
+	tcpHeader := packet.NewTCPHeader(8080, 9090)
+	tcpHeader.SetFlag(packet.FlagSYN)
+	if err := tcpHeader.SetChecksum(src, dst, nil); err != nil {
+		t.Fatalf("SetChecksum failed: %v", err)
+	}
+	segment, _ := tcpHeader.Marshal()
+
+	ipHeader := NewIPv4Header(src, dst, packet.ProtocolTCP, len(segment))
+	b, err := ipHeader.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	datagram := append(b, segment...)
+
+	var received *packet.TCPHeader
+	demux := NewDemux()
+	demux.Register(packet.ProtocolTCP, func(s, d net.IP, payload []byte) {
+		h, data, err := packet.Unmarshal(payload)
+		if err != nil {
+			t.Fatalf("Failed to decode TCP segment: %v", err)
+		}
+		if err := h.VerifyChecksum(s, d, data); err != nil {
+			t.Errorf("Checksum verification failed: %v", err)
+		}
+		received = h
+	})
+
+	if err := demux.Deliver(datagram); err != nil {
+		t.Fatalf("Deliver failed: %v", err)
+	}
+	if received == nil || !received.HasFlag(packet.FlagSYN) || received.DestinationPort != 9090 {
+		t.Errorf("Expected SYN to port 9090, got %v", received)
+	}
+}
+
+func TestDemuxErrors(t *testing.T) {
+	demux := NewDemux()
+
+	h := NewIPv4Header(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), ProtocolUDP, 0)
+	b, _ := h.Marshal()
+	if err := demux.Deliver(b); !errors.Is(err, ErrUnknownProtocol) {
+		t.Errorf("Expected ErrUnknownProtocol, got %v", err)
+	}
+
+	// A lone fragment is held for reassembly and not delivered
+	demux.Register(ProtocolUDP, func(net.IP, net.IP, []byte) {
+		t.Error("Fragment must not be delivered")
+	})
+	h.Flags = FlagMF
+	b, _ = h.Marshal()
+	if err := demux.Deliver(b); err != nil {
+		t.Errorf("Expected fragment to be queued, got %v", err)
+	}
+	if demux.Reassembler().Pending() != 1 {
+		t.Errorf("Expected 1 pending datagram, got %d", demux.Reassembler().Pending())
+	}
+
+	if err := demux.Deliver([]byte{0x45}); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package ip
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// nextID is the identification counter for outgoing IPv4 datagrams
+var nextID atomic.Uint32
+
+// Encapsulate wraps an upper layer payload into IP packets that fit mtu.
+// IPv4 is used when both addresses are IPv4 and datagrams larger than the
+// MTU are fragmented; otherwise a single IPv6 packet is built.
+func Encapsulate(src, dst net.IP, proto uint8, payload []byte, mtu int) ([][]byte, error) {
+	if src.To4() != nil && dst.To4() != nil {
+		h := NewIPv4Header(src, dst, proto, len(payload))
+		h.ID = uint16(nextID.Add(1))
+		return Fragment(h, payload, mtu)
+	}
+
+	h := NewIPv6Header(src, dst, proto, len(payload))
+	if IPv6HeaderLength+len(payload) > mtu {
+		return nil, fmt.Errorf("%w: %d bytes, MTU %d", ErrDontFragment, IPv6HeaderLength+len(payload), mtu)
+	}
+	b, err := h.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{append(b, payload...)}, nil
+}
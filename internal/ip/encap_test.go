@@ -0,0 +1,34 @@
+package ip
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+)
+
+func TestEncapsulate(t *testing.T) {
+	payload := makePayload(3000)
+
+	packets, err := Encapsulate(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), packet.ProtocolTCP, payload, 1500)
+	if err != nil {
+		t.Fatalf("Encapsulate failed: %v", err)
+	}
+	if len(packets) != 3 {
+		t.Errorf("Expected 3 IPv4 fragments, got %d", len(packets))
+	}
+	first, _, _ := UnmarshalIPv4(packets[0])
+	last, _, _ := UnmarshalIPv4(packets[len(packets)-1])
+	if first.ID != last.ID {
+		t.Errorf("Fragments must share the same ID, got %d and %d", first.ID, last.ID)
+	}
+
+	v6, err := Encapsulate(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), packet.ProtocolTCP, payload[:100], 1500)
+	if err != nil || len(v6) != 1 || v6[0][0]>>4 != IPv6Version {
+		t.Fatalf("Expected a single IPv6 packet, got %d (%v)", len(v6), err)
+	}
+	if _, err := Encapsulate(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), packet.ProtocolTCP, payload, 1500); !errors.Is(err, ErrDontFragment) {
+		t.Errorf("Expected ErrDontFragment for oversized IPv6 packet, got %v", err)
+	}
+}
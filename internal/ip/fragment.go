@@ -0,0 +1,314 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Reassembly defaults
+const (
+	DefaultReassemblyTimeout = 30 * time.Second
+	DefaultReassemblyMemory  = 4 * 1024 * 1024
+	maxDatagramPayload       = 65535 - IPv4MinHeaderLength
+)
+
+// Errors returned by fragmentation and reassembly
+var (
+	ErrDontFragment  = errors.New("ip: datagram exceeds MTU and DF is set")
+	ErrMTUTooSmall   = errors.New("ip: MTU too small to fragment")
+	ErrBadFragment   = errors.New("ip: inconsistent fragment")
+	ErrReassemblyMem = errors.New("ip: reassembly memory limit exceeded")
+)
+
+// Fragment encodes a datagram, splitting it into fragments that fit mtu.
+// h describes the original datagram; its TotalLength, ID and flags are
+// used as the base for every fragment. Only options with the copied flag
+// are repeated after the first fragment (RFC 791).
+func Fragment(h *IPv4Header, payload []byte, mtu int) ([][]byte, error) {
+	if h.HeaderLength()+len(payload) <= mtu {
+		frag := *h
+		frag.TotalLength = uint16(h.HeaderLength() + len(payload))
+		b, err := frag.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{append(b, payload...)}, nil
+	}
+	if h.HasFlag(FlagDF) {
+		return nil, fmt.Errorf("%w: %d bytes, MTU %d", ErrDontFragment, h.HeaderLength()+len(payload), mtu)
+	}
+
+	var datagrams [][]byte
+	offset := 0
+	for offset < len(payload) {
+		frag := *h
+		if offset > 0 {
+			frag.Options = copiedOptions(h.Options)
+		}
+
+		// Fragment data must be a multiple of 8 bytes except for the last one
+		size := (mtu - frag.HeaderLength()) &^ 7
+		if size <= 0 {
+			return nil, fmt.Errorf("%w: %d", ErrMTUTooSmall, mtu)
+		}
+		last := offset+size >= len(payload)
+		if last {
+			size = len(payload) - offset
+		}
+
+		frag.FragmentOffset = h.FragmentOffset + uint16(offset/8)
+		frag.Flags = h.Flags | FlagMF
+		if last {
+			// The last piece keeps the MF flag of the original datagram
+			frag.Flags = h.Flags
+		}
+		frag.TotalLength = uint16(frag.HeaderLength() + size)
+
+		b, err := frag.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		datagrams = append(datagrams, append(b, payload[offset:offset+size]...))
+		offset += size
+	}
+
+	return datagrams, nil
+}
+
+// copiedOptions returns the options that must be repeated in every fragment
+func copiedOptions(opts []byte) []byte {
+	var copied []byte
+	for len(opts) > 0 {
+		kind := opts[0]
+		if kind == 0 { // End of option list
+			break
+		}
+		length := 1
+		if kind != 1 { // Everything except NOP has a length byte
+			if len(opts) < 2 || opts[1] < 2 || int(opts[1]) > len(opts) {
+				break
+			}
+			length = int(opts[1])
+		}
+		if kind&0x80 != 0 { // Copied flag
+			copied = append(copied, opts[:length]...)
+		}
+		opts = opts[length:]
+	}
+
+	// Pad with EOL to a 32-bit boundary
+	for len(copied)%4 != 0 {
+		copied = append(copied, 0)
+	}
+	return copied
+}
+
+// fragmentKey identifies the fragments of one datagram (RFC 791)
+type fragmentKey struct {
+	src   [4]byte
+	dst   [4]byte
+	id    uint16
+	proto uint8
+}
+
+// span is a received byte range [start, end) of the original payload
+type span struct {
+	start int
+	end   int
+}
+
+// fragmentBuffer collects the fragments of one datagram
+type fragmentBuffer struct {
+	header   *IPv4Header // Header of the first fragment, once received
+	data     []byte
+	received []span // Sorted and coalesced
+	total    int    // Payload length, -1 until the last fragment arrives
+	deadline time.Time
+}
+
+// Reassembler rebuilds IPv4 datagrams from their fragments
+type Reassembler struct {
+	mu       sync.Mutex
+	buffers  map[fragmentKey]*fragmentBuffer
+	order    []fragmentKey // Oldest first, for eviction
+	used     int
+	timeout  time.Duration
+	maxBytes int
+	now      func() time.Time
+}
+
+// NewReassembler creates a reassembler that drops incomplete datagrams
+// after timeout and keeps at most maxBytes of fragment data
+func NewReassembler(timeout time.Duration, maxBytes int) *Reassembler {
+	return &Reassembler{
+		buffers:  make(map[fragmentKey]*fragmentBuffer),
+		timeout:  timeout,
+		maxBytes: maxBytes,
+		now:      time.Now,
+	}
+}
+
+// Process adds a fragment. When the datagram is complete it returns the
+// reassembled header and payload with done set to true.
+// Bytes already received win over overlapping data from later fragments.
+func (r *Reassembler) Process(h *IPv4Header, payload []byte) (*IPv4Header, []byte, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	r.expireLocked(now)
+
+	key := fragmentKey{id: h.ID, proto: h.Protocol}
+	copy(key.src[:], h.Src.To4())
+	copy(key.dst[:], h.Dst.To4())
+
+	start := int(h.FragmentOffset) * 8
+	end := start + len(payload)
+	more := h.HasFlag(FlagMF)
+	if end > maxDatagramPayload || (more && len(payload)%8 != 0) {
+		r.dropLocked(key)
+		return nil, nil, false, fmt.Errorf("%w: id %d offset %d length %d", ErrBadFragment, h.ID, start, len(payload))
+	}
+
+	buf, ok := r.buffers[key]
+	if !ok {
+		buf = &fragmentBuffer{total: -1, deadline: now.Add(r.timeout)}
+		r.buffers[key] = buf
+		r.order = append(r.order, key)
+	}
+
+	// The end of the datagram must be consistent across fragments
+	if (!more && buf.total >= 0 && end != buf.total) ||
+		(!more && len(buf.received) > 0 && buf.received[len(buf.received)-1].end > end) ||
+		(buf.total >= 0 && end > buf.total) {
+		r.dropLocked(key)
+		return nil, nil, false, fmt.Errorf("%w: id %d has conflicting length", ErrBadFragment, h.ID)
+	}
+	if !more {
+		buf.total = end
+	}
+	if start == 0 {
+		first := *h
+		buf.header = &first
+	}
+
+	// Keep memory usage under the cap by evicting the oldest datagrams
+	grow := max(0, end-len(buf.data))
+	if grow > r.maxBytes {
+		r.dropLocked(key)
+		return nil, nil, false, fmt.Errorf("%w: datagram id %d", ErrReassemblyMem, h.ID)
+	}
+	for r.used+grow > r.maxBytes && len(r.order) > 0 && r.order[0] != key {
+		r.dropLocked(r.order[0])
+	}
+	if r.used+grow > r.maxBytes {
+		r.dropLocked(key)
+		return nil, nil, false, fmt.Errorf("%w: datagram id %d", ErrReassemblyMem, h.ID)
+	}
+	if grow > 0 {
+		buf.data = append(buf.data, make([]byte, grow)...)
+		r.used += grow
+	}
+
+	buf.add(start, payload)
+
+	if buf.header == nil || buf.total < 0 || len(buf.received) != 1 || buf.received[0].end != buf.total {
+		return nil, nil, false, nil
+	}
+
+	// Complete: rebuild an unfragmented header
+	full := *buf.header
+	full.Flags &^= FlagMF
+	full.FragmentOffset = 0
+	full.TotalLength = uint16(full.HeaderLength() + buf.total)
+	data := buf.data[:buf.total]
+	r.dropLocked(key)
+
+	return &full, data, true, nil
+}
+
+// add copies the bytes of a fragment that fall into holes
+func (b *fragmentBuffer) add(start int, payload []byte) {
+	end := start + len(payload)
+	pos := start
+	for _, s := range b.received {
+		if s.end <= pos || s.start >= end {
+			continue
+		}
+		if s.start > pos {
+			copy(b.data[pos:s.start], payload[pos-start:])
+		}
+		pos = max(pos, s.end)
+	}
+	if pos < end {
+		copy(b.data[pos:end], payload[pos-start:])
+	}
+
+	// Insert the new span and coalesce neighbours
+	spans := append(b.received, span{start, end})
+	for i := len(spans) - 1; i > 0 && spans[i].start < spans[i-1].start; i-- {
+		spans[i], spans[i-1] = spans[i-1], spans[i]
+	}
+	merged := spans[:1]
+	for _, s := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if s.start <= last.end {
+			last.end = max(last.end, s.end)
+		} else {
+			merged = append(merged, s)
+		}
+	}
+	b.received = merged
+}
+
+// Expire drops incomplete datagrams whose timeout has passed and
+// returns how many were dropped
+func (r *Reassembler) Expire() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.expireLocked(r.now())
+}
+
+// Pending returns the number of incomplete datagrams
+func (r *Reassembler) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.buffers)
+}
+
+// MemoryUsed returns the bytes currently held for reassembly
+func (r *Reassembler) MemoryUsed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.used
+}
+
+func (r *Reassembler) expireLocked(now time.Time) int {
+	expired := 0
+	for len(r.order) > 0 {
+		buf := r.buffers[r.order[0]]
+		if now.Before(buf.deadline) {
+			break
+		}
+		r.dropLocked(r.order[0])
+		expired++
+	}
+	return expired
+}
+
+func (r *Reassembler) dropLocked(key fragmentKey) {
+	buf, ok := r.buffers[key]
+	if !ok {
+		return
+	}
+	r.used -= len(buf.data)
+	delete(r.buffers, key)
+	for i, k := range r.order {
+		if k == key {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
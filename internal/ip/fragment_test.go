@@ -0,0 +1,221 @@
+package ip
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+)
+
+func makePayload(n int) []byte {
+	payload := make([]byte, n)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	return payload
+}
+
+func TestFragmentAndReassemble(t *testing.T) {
+	payload := makePayload(3000)
+	h := NewIPv4Header(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), packet.ProtocolTCP, len(payload))
+	h.ID = 42
+
+	fragments, err := Fragment(h, payload, 1280)
+	if err != nil {
+		t.Fatalf("Fragment failed: %v", err)
+	}
+	if len(fragments) != 3 {
+		t.Fatalf("Expected 3 fragments, got %d", len(fragments))
+	}
+
+	r := NewReassembler(time.Second, 1<<20)
+	// Deliver in reverse order
+	for i := len(fragments) - 1; i >= 0; i-- {
+		if len(fragments[i]) > 1280 {
+			t.Errorf("Fragment %d is %d bytes, larger than MTU", i, len(fragments[i]))
+		}
+		fh, data, err := UnmarshalIPv4(fragments[i])
+		if err != nil {
+			t.Fatalf("Unmarshal fragment %d failed: %v", i, err)
+		}
+		if i < len(fragments)-1 && (!fh.HasFlag(FlagMF) || len(data)%8 != 0) {
+			t.Errorf("Fragment %d should have MF and 8-byte aligned data: %s", i, fh)
+		}
+
+		full, reassembled, done, err := r.Process(fh, data)
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if done != (i == 0) {
+			t.Fatalf("Fragment %d: unexpected done=%v", i, done)
+		}
+		if done {
+			if !bytes.Equal(reassembled, payload) {
+				t.Error("Reassembled payload does not match")
+			}
+			if full.IsFragment() || int(full.TotalLength) != IPv4MinHeaderLength+len(payload) {
+				t.Errorf("Unexpected reassembled header: %s", full)
+			}
+		}
+	}
+
+	if r.Pending() != 0 || r.MemoryUsed() != 0 {
+		t.Errorf("Expected empty reassembler, got %d pending and %d bytes", r.Pending(), r.MemoryUsed())
+	}
+}
+
+func TestFragmentSmallDatagramAndDF(t *testing.T) {
+	payload := makePayload(100)
+	h := NewIPv4Header(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), packet.ProtocolTCP, len(payload))
+
+	fragments, err := Fragment(h, payload, 1500)
+	if err != nil || len(fragments) != 1 {
+		t.Fatalf("Expected a single datagram, got %d (%v)", len(fragments), err)
+	}
+
+	h.Flags = FlagDF
+	if _, err := Fragment(h, payload, 60); !errors.Is(err, ErrDontFragment) {
+		t.Errorf("Expected ErrDontFragment, got %v", err)
+	}
+
+	h.Flags = 0
+	if _, err := Fragment(h, payload, 24); !errors.Is(err, ErrMTUTooSmall) {
+		t.Errorf("Expected ErrMTUTooSmall, got %v", err)
+	}
+}
+
+func TestFragmentCopiesOptions(t *testing.T) {
+	payload := makePayload(64)
+	h := NewIPv4Header(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), packet.ProtocolTCP, len(payload))
+	// Copied option (0x94 router alert) followed by a non-copied one (0x07 record route)
+	h.Options = []byte{0x94, 4, 0, 0, 0x07, 3, 4, 0}
+
+	fragments, err := Fragment(h, payload, 60)
+	if err != nil {
+		t.Fatalf("Fragment failed: %v", err)
+	}
+	first, _, _ := UnmarshalIPv4(fragments[0])
+	second, _, _ := UnmarshalIPv4(fragments[1])
+	if len(first.Options) != 8 {
+		t.Errorf("First fragment should carry all options, got %v", first.Options)
+	}
+	if !bytes.Equal(second.Options, []byte{0x94, 4, 0, 0}) {
+		t.Errorf("Later fragments should only carry copied options, got %v", second.Options)
+	}
+}
+
+func TestReassemblyOverlap(t *testing.T) {
+	payload := makePayload(32)
+	h := NewIPv4Header(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), packet.ProtocolTCP, 0)
+	h.ID = 7
+
+	fragment := func(offset, length int, more bool) (*IPv4Header, []byte) {
+		fh := *h
+		fh.FragmentOffset = uint16(offset / 8)
+		if more {
+			fh.Flags = FlagMF
+		}
+		return &fh, payload[offset : offset+length]
+	}
+
+	r := NewReassembler(time.Second, 1<<20)
+	r.Process(fragment(0, 16, true))
+
+	// Overlapping fragment with different content: first received bytes win
+	fh, data := fragment(8, 16, true)
+	bogus := bytes.Repeat([]byte{0xff}, len(data))
+	if _, _, done, err := r.Process(fh, bogus); err != nil || done {
+		t.Fatalf("Unexpected result for overlapping fragment: done=%v err=%v", done, err)
+	}
+
+	// Duplicate of the first fragment is harmless
+	r.Process(fragment(0, 16, true))
+
+	fh, data = fragment(16, 16, false)
+	_, reassembled, done, err := r.Process(fh, data)
+	if err != nil || !done {
+		t.Fatalf("Expected reassembly to complete: done=%v err=%v", done, err)
+	}
+	want := append(append([]byte(nil), payload[:16]...), append(bogus[8:], payload[24:]...)...)
+	if !bytes.Equal(reassembled, want) {
+		t.Errorf("Unexpected reassembled data:\n got  %v\n want %v", reassembled, want)
+	}
+
+	// A second last fragment that disagrees on the length drops the datagram
+	r.Process(fragment(0, 8, true))
+	r.Process(fragment(16, 16, false))
+	if _, _, _, err := r.Process(fragment(8, 16, false)); !errors.Is(err, ErrBadFragment) {
+		t.Errorf("Expected ErrBadFragment, got %v", err)
+	}
+	if r.Pending() != 0 {
+		t.Errorf("Expected inconsistent datagram to be dropped, %d pending", r.Pending())
+	}
+}
+
+func TestReassemblyTimeoutAndMemoryCap(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := NewReassembler(10*time.Second, 64)
+	r.now = func() time.Time { return now }
+
+	h := NewIPv4Header(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), packet.ProtocolTCP, 0)
+	h.Flags = FlagMF
+
+	h.ID = 1
+	r.Process(h, makePayload(32))
+	now = now.Add(5 * time.Second)
+	h.ID = 2
+	r.Process(h, makePayload(32))
+	if r.Pending() != 2 || r.MemoryUsed() != 64 {
+		t.Fatalf("Expected 2 pending datagrams using 64 bytes, got %d and %d", r.Pending(), r.MemoryUsed())
+	}
+
+	// Going over the cap evicts the oldest datagram
+	h.ID = 3
+	if _, _, _, err := r.Process(h, makePayload(16)); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if r.Pending() != 2 || r.MemoryUsed() != 48 {
+		t.Errorf("Expected eviction to leave 2 datagrams using 48 bytes, got %d and %d", r.Pending(), r.MemoryUsed())
+	}
+
+	// A datagram larger than the cap is rejected
+	h.ID = 4
+	if _, _, _, err := r.Process(h, makePayload(128)); !errors.Is(err, ErrReassemblyMem) {
+		t.Errorf("Expected ErrReassemblyMem, got %v", err)
+	}
+
+	// Incomplete datagrams expire
+	now = now.Add(11 * time.Second)
+	if expired := r.Expire(); expired != 2 {
+		t.Errorf("Expected 2 expired datagrams, got %d", expired)
+	}
+	if r.Pending() != 0 || r.MemoryUsed() != 0 {
+		t.Errorf("Expected empty reassembler, got %d pending and %d bytes", r.Pending(), r.MemoryUsed())
+	}
+}
+
+func TestDemuxReassemblesFragments(t *testing.T) {
+	payload := makePayload(2000)
+	h := NewIPv4Header(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), packet.ProtocolTCP, len(payload))
+	fragments, err := Fragment(h, payload, 576)
+	if err != nil {
+		t.Fatalf("Fragment failed: %v", err)
+	}
+
+	var delivered []byte
+	demux := NewDemux()
+	demux.Register(packet.ProtocolTCP, func(_, _ net.IP, p []byte) {
+		delivered = append([]byte(nil), p...)
+	})
+	for _, f := range fragments {
+		if err := demux.Deliver(f); err != nil {
+			t.Fatalf("Deliver failed: %v", err)
+		}
+	}
+	if !bytes.Equal(delivered, payload) {
+		t.Errorf("Expected reassembled payload of %d bytes, got %d", len(payload), len(delivered))
+	}
+}
@@ -0,0 +1,171 @@
+// Package ip implements a minimal IP layer for TinyTCP
+package ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+)
+
+// IP protocol numbers (TCP's is packet.ProtocolTCP)
+const (
+	ProtocolICMP = 1
+	ProtocolUDP  = 17
+)
+
+// IPv4 header constants
+const (
+	IPv4Version         = 4
+	IPv4MinHeaderLength = 20
+	IPv4MaxHeaderLength = 60
+	DefaultTTL          = 64
+)
+
+// IPv4 fragmentation flags (3-bit field)
+const (
+	FlagMF = 1 << 0 // More fragments
+	FlagDF = 1 << 1 // Don't fragment
+)
+
+// Errors returned when decoding or encoding IP headers
+var (
+	ErrTruncated      = errors.New("ip: truncated datagram")
+	ErrInvalidVersion = errors.New("ip: invalid IP version")
+	ErrInvalidHeader  = errors.New("ip: invalid header length")
+	ErrBadChecksum    = errors.New("ip: header checksum mismatch")
+)
+
+// IPv4Header represents the IPv4 header structure
+// Based on RFC 791: https://tools.ietf.org/html/rfc791
+type IPv4Header struct {
+	TOS            uint8  // Type of service
+	TotalLength    uint16 // Header + payload length in bytes
+	ID             uint16 // Identification
+	Flags          uint8  // DF / MF
+	FragmentOffset uint16 // Fragment offset in 8-byte units
+	TTL            uint8  // Time to live
+	Protocol       uint8  // Upper layer protocol
+	Checksum       uint16 // Header checksum
+	Src            net.IP // Source address
+	Dst            net.IP // Destination address
+	Options        []byte // Raw options (multiple of 4 bytes)
+}
+
+// NewIPv4Header creates an IPv4 header carrying payloadLen bytes of proto
+func NewIPv4Header(src, dst net.IP, proto uint8, payloadLen int) *IPv4Header {
+	return &IPv4Header{
+		TotalLength: uint16(IPv4MinHeaderLength + payloadLen),
+		TTL:         DefaultTTL,
+		Protocol:    proto,
+		Src:         src,
+		Dst:         dst,
+	}
+}
+
+// HeaderLength returns the header length in bytes
+func (h *IPv4Header) HeaderLength() int {
+	return IPv4MinHeaderLength + len(h.Options)
+}
+
+// HasFlag checks if a fragmentation flag is set
+func (h *IPv4Header) HasFlag(flag uint8) bool {
+	return h.Flags&flag != 0
+}
+
+// IsFragment reports whether the datagram is part of a fragmented datagram
+func (h *IPv4Header) IsFragment() bool {
+	return h.HasFlag(FlagMF) || h.FragmentOffset != 0
+}
+
+// Marshal encodes the header and fills in the header checksum
+func (h *IPv4Header) Marshal() ([]byte, error) {
+	if len(h.Options)%4 != 0 || h.HeaderLength() > IPv4MaxHeaderLength {
+		return nil, fmt.Errorf("%w: %d bytes of options", ErrInvalidHeader, len(h.Options))
+	}
+	src, dst := h.Src.To4(), h.Dst.To4()
+	if src == nil || dst == nil {
+		return nil, fmt.Errorf("ip: not an IPv4 address pair: %v -> %v", h.Src, h.Dst)
+	}
+
+	b := make([]byte, h.HeaderLength())
+	b[0] = IPv4Version<<4 | uint8(h.HeaderLength()/4)
+	b[1] = h.TOS
+	binary.BigEndian.PutUint16(b[2:4], h.TotalLength)
+	binary.BigEndian.PutUint16(b[4:6], h.ID)
+	// Flags (3 bits) | Fragment offset (13 bits)
+	binary.BigEndian.PutUint16(b[6:8], uint16(h.Flags&0x7)<<13|h.FragmentOffset&0x1fff)
+	b[8] = h.TTL
+	b[9] = h.Protocol
+	copy(b[12:16], src)
+	copy(b[16:20], dst)
+	copy(b[20:], h.Options)
+
+	h.Checksum = packet.InternetChecksum(b, 0)
+	binary.BigEndian.PutUint16(b[10:12], h.Checksum)
+
+	return b, nil
+}
+
+// UnmarshalIPv4 decodes an IPv4 datagram and returns its header and payload.
+// The header checksum is verified. The returned payload shares memory with b.
+func UnmarshalIPv4(b []byte) (*IPv4Header, []byte, error) {
+	if len(b) < IPv4MinHeaderLength {
+		return nil, nil, fmt.Errorf("%w: %d bytes", ErrTruncated, len(b))
+	}
+	if b[0]>>4 != IPv4Version {
+		return nil, nil, fmt.Errorf("%w: %d", ErrInvalidVersion, b[0]>>4)
+	}
+
+	headerLen := int(b[0]&0x0f) * 4
+	if headerLen < IPv4MinHeaderLength || headerLen > len(b) {
+		return nil, nil, fmt.Errorf("%w: %d bytes", ErrInvalidHeader, headerLen)
+	}
+	if packet.InternetChecksum(b[:headerLen], 0) != 0 {
+		return nil, nil, ErrBadChecksum
+	}
+
+	flagsOffset := binary.BigEndian.Uint16(b[6:8])
+	h := &IPv4Header{
+		TOS:            b[1],
+		TotalLength:    binary.BigEndian.Uint16(b[2:4]),
+		ID:             binary.BigEndian.Uint16(b[4:6]),
+		Flags:          uint8(flagsOffset >> 13),
+		FragmentOffset: flagsOffset & 0x1fff,
+		TTL:            b[8],
+		Protocol:       b[9],
+		Checksum:       binary.BigEndian.Uint16(b[10:12]),
+		Src:            net.IP(append([]byte(nil), b[12:16]...)),
+		Dst:            net.IP(append([]byte(nil), b[16:20]...)),
+	}
+	if headerLen > IPv4MinHeaderLength {
+		h.Options = append([]byte(nil), b[IPv4MinHeaderLength:headerLen]...)
+	}
+
+	totalLen := int(h.TotalLength)
+	if totalLen < headerLen {
+		return nil, nil, fmt.Errorf("%w: total length %d", ErrInvalidHeader, totalLen)
+	}
+	if totalLen > len(b) {
+		return nil, nil, fmt.Errorf("%w: total length %d exceeds %d bytes", ErrTruncated, totalLen, len(b))
+	}
+
+	// Anything past TotalLength is link layer padding
+	return h, b[headerLen:totalLen], nil
+}
+
+// String returns a string representation of the IPv4 header
+func (h *IPv4Header) String() string {
+	flags := ""
+	if h.HasFlag(FlagDF) {
+		flags += "DF "
+	}
+	if h.HasFlag(FlagMF) {
+		flags += "MF "
+	}
+
+	return fmt.Sprintf("IPv4[%s->%s proto=%d id=%d flags=%soff=%d ttl=%d len=%d]",
+		h.Src, h.Dst, h.Protocol, h.ID, flags, h.FragmentOffset*8, h.TTL, h.TotalLength)
+}
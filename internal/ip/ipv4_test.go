@@ -0,0 +1,106 @@
+package ip
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+)
+
+func TestIPv4MarshalUnmarshal(t *testing.T) {
+	payload := []byte("tcp segment")
+	h := NewIPv4Header(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), packet.ProtocolTCP, len(payload))
+	h.ID = 0x1234
+	h.Flags = FlagDF
+
+	b, err := h.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if len(b) != IPv4MinHeaderLength {
+		t.Fatalf("Expected %d bytes, got %d", IPv4MinHeaderLength, len(b))
+	}
+	if b[0] != 0x45 {
+		t.Errorf("Expected version/IHL 0x45, got %#02x", b[0])
+	}
+	if b[6] != 0x40 || b[7] != 0x00 {
+		t.Errorf("Expected DF flag bytes 0x4000, got %#02x%02x", b[6], b[7])
+	}
+
+	decoded, data, err := UnmarshalIPv4(append(b, payload...))
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if string(data) != string(payload) {
+		t.Errorf("Expected payload %q, got %q", payload, data)
+	}
+	if !decoded.Src.Equal(h.Src) || !decoded.Dst.Equal(h.Dst) {
+		t.Errorf("Address mismatch: %s", decoded)
+	}
+	if decoded.ID != 0x1234 || decoded.TTL != DefaultTTL || decoded.Protocol != packet.ProtocolTCP {
+		t.Errorf("Field mismatch: %s", decoded)
+	}
+	if !decoded.HasFlag(FlagDF) || decoded.IsFragment() {
+		t.Errorf("Expected DF and no fragmentation: %s", decoded)
+	}
+	t.Logf("IPv4 Header: %s", decoded)
+}
+
+func TestIPv4Options(t *testing.T) {
+	h := NewIPv4Header(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), packet.ProtocolTCP, 0)
+	h.Options = []byte{1, 1, 1, 0} // NOP NOP NOP EOL
+	h.TotalLength += uint16(len(h.Options))
+
+	b, err := h.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if b[0] != 0x46 {
+		t.Errorf("Expected IHL 6, got %#02x", b[0])
+	}
+
+	decoded, data, err := UnmarshalIPv4(b)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if len(decoded.Options) != 4 || len(data) != 0 {
+		t.Errorf("Expected 4 bytes of options and no payload, got %d and %d", len(decoded.Options), len(data))
+	}
+
+	h.Options = []byte{1, 1}
+	if _, err := h.Marshal(); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader for unaligned options, got %v", err)
+	}
+}
+
+func TestIPv4UnmarshalErrors(t *testing.T) {
+	h := NewIPv4Header(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), packet.ProtocolTCP, 4)
+	b, _ := h.Marshal()
+	valid := append(b, 1, 2, 3, 4)
+
+	if _, _, err := UnmarshalIPv4(valid[:10]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+	if _, _, err := UnmarshalIPv4(valid[:22]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated for short payload, got %v", err)
+	}
+
+	corrupted := append([]byte(nil), valid...)
+	corrupted[8]-- // TTL changed without updating the checksum
+	if _, _, err := UnmarshalIPv4(corrupted); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("Expected ErrBadChecksum, got %v", err)
+	}
+
+	wrongVersion := append([]byte(nil), valid...)
+	wrongVersion[0] = 0x65
+	if _, _, err := UnmarshalIPv4(wrongVersion); !errors.Is(err, ErrInvalidVersion) {
+		t.Errorf("Expected ErrInvalidVersion, got %v", err)
+	}
+
+	// Trailing link layer padding is ignored
+	_, data, err := UnmarshalIPv4(append(valid, 0, 0))
+	if err != nil || len(data) != 4 {
+		t.Errorf("Expected 4 byte payload ignoring padding, got %d (%v)", len(data), err)
+	}
+}
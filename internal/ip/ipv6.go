@@ -0,0 +1,134 @@
+package ip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// IPv6 header constants
+const (
+	IPv6Version      = 6
+	IPv6HeaderLength = 40
+	DefaultHopLimit  = 64
+)
+
+// IPv6 extension header types (next header values)
+const (
+	ProtocolHopByHop    = 0
+	ProtocolRouting     = 43
+	ProtocolFragment    = 44
+	ProtocolNoNext      = 59
+	ProtocolDestOptions = 60
+)
+
+// IPv6Header represents the fixed IPv6 header
+// Based on RFC 8200: https://tools.ietf.org/html/rfc8200
+type IPv6Header struct {
+	TrafficClass  uint8  // Traffic class
+	FlowLabel     uint32 // Flow label (20 bits)
+	PayloadLength uint16 // Length of everything after the fixed header
+	NextHeader    uint8  // First extension header or upper layer protocol
+	HopLimit      uint8  // Hop limit
+	Src           net.IP // Source address
+	Dst           net.IP // Destination address
+}
+
+// NewIPv6Header creates an IPv6 header carrying payloadLen bytes of proto
+func NewIPv6Header(src, dst net.IP, proto uint8, payloadLen int) *IPv6Header {
+	return &IPv6Header{
+		PayloadLength: uint16(payloadLen),
+		NextHeader:    proto,
+		HopLimit:      DefaultHopLimit,
+		Src:           src,
+		Dst:           dst,
+	}
+}
+
+// Marshal encodes the fixed header
+func (h *IPv6Header) Marshal() ([]byte, error) {
+	src, dst := h.Src.To16(), h.Dst.To16()
+	if src == nil || dst == nil || h.Src.To4() != nil || h.Dst.To4() != nil {
+		return nil, fmt.Errorf("ip: not an IPv6 address pair: %v -> %v", h.Src, h.Dst)
+	}
+
+	b := make([]byte, IPv6HeaderLength)
+	// Version (4 bits) | Traffic class (8 bits) | Flow label (20 bits)
+	binary.BigEndian.PutUint32(b[0:4],
+		uint32(IPv6Version)<<28|uint32(h.TrafficClass)<<20|h.FlowLabel&0xfffff)
+	binary.BigEndian.PutUint16(b[4:6], h.PayloadLength)
+	b[6] = h.NextHeader
+	b[7] = h.HopLimit
+	copy(b[8:24], src)
+	copy(b[24:40], dst)
+
+	return b, nil
+}
+
+// UnmarshalIPv6 decodes the fixed header of an IPv6 packet and returns it
+// together with the rest of the packet, including any extension headers.
+func UnmarshalIPv6(b []byte) (*IPv6Header, []byte, error) {
+	if len(b) < IPv6HeaderLength {
+		return nil, nil, fmt.Errorf("%w: %d bytes", ErrTruncated, len(b))
+	}
+	if b[0]>>4 != IPv6Version {
+		return nil, nil, fmt.Errorf("%w: %d", ErrInvalidVersion, b[0]>>4)
+	}
+
+	first := binary.BigEndian.Uint32(b[0:4])
+	h := &IPv6Header{
+		TrafficClass:  uint8(first >> 20),
+		FlowLabel:     first & 0xfffff,
+		PayloadLength: binary.BigEndian.Uint16(b[4:6]),
+		NextHeader:    b[6],
+		HopLimit:      b[7],
+		Src:           net.IP(append([]byte(nil), b[8:24]...)),
+		Dst:           net.IP(append([]byte(nil), b[24:40]...)),
+	}
+
+	end := IPv6HeaderLength + int(h.PayloadLength)
+	if end > len(b) {
+		return nil, nil, fmt.Errorf("%w: payload length %d exceeds %d bytes",
+			ErrTruncated, h.PayloadLength, len(b)-IPv6HeaderLength)
+	}
+
+	return h, b[IPv6HeaderLength:end], nil
+}
+
+// SkipExtensionHeaders walks the extension header chain starting at next
+// and returns the upper layer protocol and its payload. Hop-by-hop, routing
+// and destination options are skipped; a fragment header is only accepted
+// when it describes an atomic fragment, since IPv6 reassembly is not supported.
+func SkipExtensionHeaders(next uint8, b []byte) (uint8, []byte, error) {
+	for {
+		switch next {
+		case ProtocolHopByHop, ProtocolRouting, ProtocolDestOptions:
+			// Next header (1) | Hdr ext len in 8-octet units, excluding the first (1) | ...
+			if len(b) < 2 {
+				return 0, nil, fmt.Errorf("%w: extension header %d", ErrTruncated, next)
+			}
+			length := (int(b[1]) + 1) * 8
+			if length > len(b) {
+				return 0, nil, fmt.Errorf("%w: extension header %d", ErrTruncated, next)
+			}
+			next, b = b[0], b[length:]
+		case ProtocolFragment:
+			// Next header (1) | Reserved (1) | Offset (13 bits) | Res (2) | M (1) | ID (4)
+			if len(b) < 8 {
+				return 0, nil, fmt.Errorf("%w: fragment header", ErrTruncated)
+			}
+			if binary.BigEndian.Uint16(b[2:4])&0xfff9 != 0 {
+				return 0, nil, fmt.Errorf("%w: id %d", ErrFragmented, binary.BigEndian.Uint32(b[4:8]))
+			}
+			next, b = b[0], b[8:]
+		default:
+			return next, b, nil
+		}
+	}
+}
+
+// String returns a string representation of the IPv6 header
+func (h *IPv6Header) String() string {
+	return fmt.Sprintf("IPv6[%s->%s next=%d hlim=%d len=%d]",
+		h.Src, h.Dst, h.NextHeader, h.HopLimit, h.PayloadLength)
+}
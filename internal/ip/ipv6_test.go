@@ -0,0 +1,128 @@
+package ip
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+)
+
+func TestIPv6MarshalUnmarshal(t *testing.T) {
+	src := net.ParseIP("2001:db8::1")
+	dst := net.ParseIP("2001:db8::2")
+	payload := []byte("tcp segment")
+
+	h := NewIPv6Header(src, dst, packet.ProtocolTCP, len(payload))
+	h.TrafficClass = 0xb8
+	h.FlowLabel = 0x12345
+
+	b, err := h.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if len(b) != IPv6HeaderLength {
+		t.Fatalf("Expected %d bytes, got %d", IPv6HeaderLength, len(b))
+	}
+
+	decoded, data, err := UnmarshalIPv6(append(b, payload...))
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.TrafficClass != 0xb8 || decoded.FlowLabel != 0x12345 {
+		t.Errorf("Expected traffic class 0xb8 and flow label 0x12345, got %#x %#x",
+			decoded.TrafficClass, decoded.FlowLabel)
+	}
+	if !decoded.Src.Equal(src) || !decoded.Dst.Equal(dst) || decoded.NextHeader != packet.ProtocolTCP {
+		t.Errorf("Field mismatch: %s", decoded)
+	}
+	if string(data) != string(payload) {
+		t.Errorf("Expected payload %q, got %q", payload, data)
+	}
+
+	if _, _, err := UnmarshalIPv6(b[:39]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+	if _, _, err := UnmarshalIPv6(b); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated for missing payload, got %v", err)
+	}
+
+	h.Src = net.IPv4(10, 0, 0, 1)
+	if _, err := h.Marshal(); err == nil {
+		t.Error("Expected error when marshaling an IPv4 address")
+	}
+}
+
+func TestSkipExtensionHeaders(t *testing.T) {
+	hopByHop := []byte{ProtocolRouting, 0, 1, 4, 0, 0, 0, 0}
+	routing := []byte{ProtocolFragment, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	atomicFragment := []byte{packet.ProtocolTCP, 0, 0, 0, 0, 0, 0, 1}
+	upper := []byte("upper")
+
+	var b []byte
+	b = append(b, hopByHop...)
+	b = append(b, routing...)
+	b = append(b, atomicFragment...)
+	b = append(b, upper...)
+
+	proto, payload, err := SkipExtensionHeaders(ProtocolHopByHop, b)
+	if err != nil {
+		t.Fatalf("SkipExtensionHeaders failed: %v", err)
+	}
+	if proto != packet.ProtocolTCP || string(payload) != "upper" {
+		t.Errorf("Expected TCP payload %q, got proto %d payload %q", "upper", proto, payload)
+	}
+
+	// A real fragment (M flag set) cannot be delivered
+	fragment := []byte{packet.ProtocolTCP, 0, 0, 1, 0, 0, 0, 1}
+	if _, _, err := SkipExtensionHeaders(ProtocolFragment, fragment); !errors.Is(err, ErrFragmented) {
+		t.Errorf("Expected ErrFragmented, got %v", err)
+	}
+
+	// Header length pointing past the end of the packet
+	if _, _, err := SkipExtensionHeaders(ProtocolDestOptions, []byte{packet.ProtocolTCP, 2, 0, 0}); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+}
+
+func TestDemuxDeliversIPv6(t *testing.T) {
+	src := net.ParseIP("fe80::1")
+	dst := net.ParseIP("fe80::2")
+
+	tcpHeader := packet.NewTCPHeader(8080, 9090)
+	tcpHeader.SetFlag(packet.FlagSYN)
+	if err := tcpHeader.SetChecksum(src, dst, nil); err != nil {
+		t.Fatalf("SetChecksum failed: %v", err)
+	}
+	segment, _ := tcpHeader.Marshal()
+
+	// Destination options header in front of the TCP segment
+	destOpts := []byte{packet.ProtocolTCP, 0, 1, 4, 0, 0, 0, 0}
+	h := NewIPv6Header(src, dst, ProtocolDestOptions, len(destOpts)+len(segment))
+	b, err := h.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	datagram := append(append(b, destOpts...), segment...)
+
+	delivered := false
+	demux := NewDemux()
+	demux.Register(packet.ProtocolTCP, func(s, d net.IP, payload []byte) {
+		th, data, err := packet.Unmarshal(payload)
+		if err != nil {
+			t.Fatalf("Failed to decode TCP segment: %v", err)
+		}
+		// IPv6 pseudo-header checksum
+		if err := th.VerifyChecksum(s, d, data); err != nil {
+			t.Errorf("Checksum verification failed: %v", err)
+		}
+		delivered = true
+	})
+
+	if err := demux.Deliver(datagram); err != nil {
+		t.Fatalf("Deliver failed: %v", err)
+	}
+	if !delivered {
+		t.Error("TCP handler was not called")
+	}
+}
@@ -0,0 +1,52 @@
+// Package link implements link layer endpoints that carry IP packets
+// between TinyTCP stacks
+package link
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// Errors returned by link endpoints
+var (
+	ErrClosed       = errors.New("link: endpoint closed")
+	ErrPacketTooBig = errors.New("link: packet exceeds MTU")
+	ErrNotConnected = errors.New("link: endpoint not connected")
+)
+
+// DeliverFunc is called with every packet received from the link.
+// The packet must not be retained after the call returns.
+type DeliverFunc func(pkt []byte)
+
+// LinkEndpoint moves raw IP packets between a stack and a link
+type LinkEndpoint interface {
+	// WritePacket sends one IP packet over the link
+	WritePacket(pkt []byte) error
+	// SetDeliver sets the callback invoked for received packets
+	SetDeliver(fn DeliverFunc)
+	// MTU returns the largest packet the link can carry
+	MTU() int
+	// Close shuts the endpoint down
+	Close() error
+}
+
+// readRetryDelay is how long a read loop waits after a transient error
+const readRetryDelay = 10 * time.Millisecond
+
+// retryRead reports whether a read loop should go on after err. A closed
+// endpoint or a closed descriptor ends the loop; other errors are retried
+// after readRetryDelay so that a persistent failure does not spin the CPU.
+func retryRead(err error, done <-chan struct{}) bool {
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrClosed) || errors.Is(err, io.EOF) {
+		return false
+	}
+	select {
+	case <-done:
+		return false
+	case <-time.After(readRetryDelay):
+		return true
+	}
+}
@@ -0,0 +1,33 @@
+package link
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRetryRead(t *testing.T) {
+	done := make(chan struct{})
+
+	for _, err := range []error{net.ErrClosed, os.ErrClosed} {
+		if retryRead(err, done) {
+			t.Errorf("Expected %v to end the read loop", err)
+		}
+	}
+
+	// Transient errors are retried, but only after a delay
+	start := time.Now()
+	if !retryRead(errors.New("transient"), done) {
+		t.Error("Expected a transient error to be retried")
+	}
+	if elapsed := time.Since(start); elapsed < readRetryDelay {
+		t.Errorf("Expected a delay of at least %v, got %v", readRetryDelay, elapsed)
+	}
+
+	close(done)
+	if retryRead(errors.New("transient"), done) {
+		t.Error("Expected a closed endpoint to end the read loop")
+	}
+}
@@ -0,0 +1,113 @@
+package link
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Compile-time check that PipeEndpoint implements LinkEndpoint
+var _ LinkEndpoint = (*PipeEndpoint)(nil)
+
+// pipeQueueSize is the number of packets buffered in each direction
+const pipeQueueSize = 256
+
+// PipeEndpoint is one end of an in-memory link between two stacks
+// in the same process. Packets are delivered asynchronously, in order.
+type PipeEndpoint struct {
+	mu      sync.RWMutex
+	peer    *PipeEndpoint
+	deliver DeliverFunc
+	mtu     int
+
+	queue     chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	dropped   atomic.Uint64
+}
+
+// NewPipe creates two connected endpoints with the given MTU
+func NewPipe(mtu int) (*PipeEndpoint, *PipeEndpoint) {
+	a := newPipeEndpoint(mtu)
+	b := newPipeEndpoint(mtu)
+	a.peer, b.peer = b, a
+	go a.run()
+	go b.run()
+	return a, b
+}
+
+func newPipeEndpoint(mtu int) *PipeEndpoint {
+	return &PipeEndpoint{
+		mtu:   mtu,
+		queue: make(chan []byte, pipeQueueSize),
+		done:  make(chan struct{}),
+	}
+}
+
+// WritePacket copies the packet to the peer's receive queue.
+// Packets are dropped, like on a real link, when the queue is full.
+func (p *PipeEndpoint) WritePacket(pkt []byte) error {
+	if len(pkt) > p.mtu {
+		return fmt.Errorf("%w: %d > %d", ErrPacketTooBig, len(pkt), p.mtu)
+	}
+	peer := p.peer
+	select {
+	case <-p.done:
+		return ErrClosed
+	case <-peer.done:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case peer.queue <- append([]byte(nil), pkt...):
+	default:
+		peer.dropped.Add(1)
+	}
+	return nil
+}
+
+// SetDeliver sets the callback for received packets
+func (p *PipeEndpoint) SetDeliver(fn DeliverFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deliver = fn
+}
+
+// MTU returns the MTU of the pipe
+func (p *PipeEndpoint) MTU() int {
+	return p.mtu
+}
+
+// Close stops delivery on this end; writes from either end then fail
+func (p *PipeEndpoint) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	return nil
+}
+
+// Dropped returns the number of packets dropped because the queue was full
+func (p *PipeEndpoint) Dropped() uint64 {
+	return p.dropped.Load()
+}
+
+// run delivers queued packets until the endpoint is closed
+func (p *PipeEndpoint) run() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case pkt := <-p.queue:
+			p.mu.RLock()
+			deliver := p.deliver
+			p.mu.RUnlock()
+
+			if deliver != nil {
+				deliver(pkt)
+			} else {
+				p.dropped.Add(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package link
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPipeDelivers(t *testing.T) {
+	a, b := NewPipe(1500)
+	defer a.Close()
+	defer b.Close()
+
+	received := make(chan []byte, 2)
+	b.SetDeliver(func(pkt []byte) {
+		received <- pkt
+	})
+
+	packet := []byte("packet one")
+	if err := a.WritePacket(packet); err != nil {
+		t.Fatalf("WritePacket failed: %v", err)
+	}
+	packet[0] = 'X' // The pipe must have copied the packet
+	if err := a.WritePacket([]byte("packet two")); err != nil {
+		t.Fatalf("WritePacket failed: %v", err)
+	}
+
+	for _, want := range []string{"packet one", "packet two"} {
+		select {
+		case got := <-received:
+			if string(got) != want {
+				t.Errorf("Expected %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %q", want)
+		}
+	}
+
+	if a.MTU() != 1500 {
+		t.Errorf("Expected MTU 1500, got %d", a.MTU())
+	}
+}
+
+func TestPipeErrors(t *testing.T) {
+	a, b := NewPipe(100)
+
+	if err := a.WritePacket(make([]byte, 101)); !errors.Is(err, ErrPacketTooBig) {
+		t.Errorf("Expected ErrPacketTooBig, got %v", err)
+	}
+
+	b.Close()
+	if err := a.WritePacket([]byte("to closed peer")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed writing to a closed peer, got %v", err)
+	}
+	if err := b.WritePacket([]byte("from closed end")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed writing from a closed end, got %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Errorf("Second Close should succeed, got %v", err)
+	}
+	a.Close()
+}
@@ -0,0 +1,187 @@
+package link
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Compile-time check that TUNEndpoint implements LinkEndpoint
+var _ LinkEndpoint = (*TUNEndpoint)(nil)
+
+// Constants from linux/if_tun.h and linux/sockios.h
+const (
+	tunDevice  = "/dev/net/tun"
+	iffTUN     = 0x0001
+	iffNoPI    = 0x1000
+	tunSetIff  = 0x400454ca
+	siocSIFMTU = 0x8922
+)
+
+// ifReq mirrors struct ifreq: the interface name followed by a union
+// holding ifr_flags (short) for TUNSETIFF or ifr_mtu (int) for SIOCSIFMTU
+type ifReq struct {
+	Name  [syscall.IFNAMSIZ]byte
+	Union [24]byte
+}
+
+// TUNEndpoint exchanges IP packets with the kernel through a TUN device.
+// The device is opened with IFF_TUN|IFF_NO_PI, so every read and write is
+// exactly one raw IP packet. Addresses and link state must be configured
+// outside TinyTCP, e.g. with `ip addr` and `ip link set up`.
+type TUNEndpoint struct {
+	file *os.File
+	name string
+	mtu  int
+
+	mu      sync.RWMutex
+	deliver DeliverFunc
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTUNEndpoint creates (or attaches to) the TUN interface name and sets
+// its MTU. An empty name lets the kernel pick one (tun0, tun1, ...).
+// Requires CAP_NET_ADMIN.
+func NewTUNEndpoint(name string, mtu int) (*TUNEndpoint, error) {
+	if len(name) >= syscall.IFNAMSIZ {
+		return nil, fmt.Errorf("link: interface name %q too long", name)
+	}
+
+	fd, err := syscall.Open(tunDevice, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("link: open %s: %w", tunDevice, err)
+	}
+
+	var req ifReq
+	copy(req.Name[:], name)
+	binary.NativeEndian.PutUint16(req.Union[:], iffTUN|iffNoPI)
+	if err := ioctl(fd, tunSetIff, &req); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("link: TUNSETIFF: %w", err)
+	}
+	name = string(req.Name[:clen(req.Name[:])])
+
+	if err := setMTU(name, mtu); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// Non-blocking mode lets the runtime poller interrupt reads on Close
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("link: set non-blocking: %w", err)
+	}
+
+	e := &TUNEndpoint{
+		file: os.NewFile(uintptr(fd), tunDevice),
+		name: name,
+		mtu:  mtu,
+		done: make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Name returns the name of the TUN interface
+func (e *TUNEndpoint) Name() string {
+	return e.name
+}
+
+// WritePacket injects an IP packet into the kernel
+func (e *TUNEndpoint) WritePacket(pkt []byte) error {
+	if len(pkt) > e.mtu {
+		return fmt.Errorf("%w: %d > %d", ErrPacketTooBig, len(pkt), e.mtu)
+	}
+	if _, err := e.file.Write(pkt); err != nil {
+		if errors.Is(err, os.ErrClosed) {
+			return ErrClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// SetDeliver sets the callback for packets routed to the interface
+func (e *TUNEndpoint) SetDeliver(fn DeliverFunc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.deliver = fn
+}
+
+// MTU returns the MTU of the interface
+func (e *TUNEndpoint) MTU() int {
+	return e.mtu
+}
+
+// Close closes the TUN device; a non-persistent interface disappears
+func (e *TUNEndpoint) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		close(e.done)
+		err = e.file.Close()
+	})
+	return err
+}
+
+// run reads packets from the device and hands them to the callback
+func (e *TUNEndpoint) run() {
+	buf := make([]byte, 65535)
+	for {
+		n, err := e.file.Read(buf)
+		if err != nil {
+			if retryRead(err, e.done) {
+				continue
+			}
+			return
+		}
+
+		e.mu.RLock()
+		deliver := e.deliver
+		e.mu.RUnlock()
+
+		if deliver != nil {
+			deliver(buf[:n])
+		}
+	}
+}
+
+// setMTU sets the MTU of an interface with SIOCSIFMTU
+func setMTU(name string, mtu int) error {
+	sock, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("link: socket: %w", err)
+	}
+	defer syscall.Close(sock)
+
+	var req ifReq
+	copy(req.Name[:], name)
+	binary.NativeEndian.PutUint32(req.Union[:], uint32(mtu))
+	if err := ioctl(sock, siocSIFMTU, &req); err != nil {
+		return fmt.Errorf("link: SIOCSIFMTU %s: %w", name, err)
+	}
+	return nil
+}
+
+func ioctl(fd int, request uintptr, req *ifReq) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(unsafe.Pointer(req)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// clen returns the length of a NUL-terminated byte string
+func clen(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
@@ -0,0 +1,35 @@
+package link
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestTUNEndpoint(t *testing.T) {
+	ep, err := NewTUNEndpoint("tinytcp%d", 1400)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+			t.Skipf("TUN device not available: %v", err)
+		}
+		t.Fatalf("NewTUNEndpoint failed: %v", err)
+	}
+	defer ep.Close()
+
+	if ep.Name() == "" || ep.Name() == "tinytcp%d" {
+		t.Errorf("Expected the kernel to assign an interface name, got %q", ep.Name())
+	}
+	if ep.MTU() != 1400 {
+		t.Errorf("Expected MTU 1400, got %d", ep.MTU())
+	}
+	if err := ep.WritePacket(make([]byte, 1401)); !errors.Is(err, ErrPacketTooBig) {
+		t.Errorf("Expected ErrPacketTooBig, got %v", err)
+	}
+
+	if err := ep.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if err := ep.WritePacket([]byte{0x45}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package link
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Compile-time check that UDPEndpoint implements LinkEndpoint
+var _ LinkEndpoint = (*UDPEndpoint)(nil)
+
+// DefaultUDPMTU keeps tunnelled packets well below the loopback MTU
+const DefaultUDPMTU = 1500
+
+// UDPEndpoint tunnels IP packets inside UDP datagrams, one packet per
+// datagram, so that two TinyTCP processes can talk without raw sockets.
+type UDPEndpoint struct {
+	conn *net.UDPConn
+	mtu  int
+
+	mu      sync.RWMutex
+	remote  *net.UDPAddr
+	deliver DeliverFunc
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewUDPEndpoint binds a UDP socket on localAddr. If remoteAddr is empty
+// the peer is learned from the first datagram received (server side).
+func NewUDPEndpoint(localAddr, remoteAddr string, mtu int) (*UDPEndpoint, error) {
+	laddr, err := net.ResolveUDPAddr("udp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var raddr *net.UDPAddr
+	if remoteAddr != "" {
+		raddr, err = net.ResolveUDPAddr("udp", remoteAddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &UDPEndpoint{
+		conn:   conn,
+		mtu:    mtu,
+		remote: raddr,
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// WritePacket sends the packet to the peer in a single UDP datagram
+func (e *UDPEndpoint) WritePacket(pkt []byte) error {
+	if len(pkt) > e.mtu {
+		return fmt.Errorf("%w: %d > %d", ErrPacketTooBig, len(pkt), e.mtu)
+	}
+
+	e.mu.RLock()
+	remote := e.remote
+	e.mu.RUnlock()
+	if remote == nil {
+		return ErrNotConnected
+	}
+
+	if _, err := e.conn.WriteToUDP(pkt, remote); err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return ErrClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// SetDeliver sets the callback for received packets
+func (e *UDPEndpoint) SetDeliver(fn DeliverFunc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.deliver = fn
+}
+
+// MTU returns the MTU of the tunnel
+func (e *UDPEndpoint) MTU() int {
+	return e.mtu
+}
+
+// LocalAddr returns the UDP address the endpoint is bound to
+func (e *UDPEndpoint) LocalAddr() *net.UDPAddr {
+	return e.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Close closes the UDP socket and stops delivery
+func (e *UDPEndpoint) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		close(e.done)
+		err = e.conn.Close()
+	})
+	return err
+}
+
+// run reads datagrams and hands them to the delivery callback
+func (e *UDPEndpoint) run() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := e.conn.ReadFromUDP(buf)
+		if err != nil {
+			if retryRead(err, e.done) {
+				continue // Transient errors such as ICMP port unreachable
+			}
+			return
+		}
+
+		e.mu.Lock()
+		if e.remote == nil {
+			e.remote = from // Learn the peer from its first datagram
+		}
+		deliver := e.deliver
+		e.mu.Unlock()
+
+		if deliver != nil {
+			deliver(buf[:n])
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package link
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUDPEndpoint(t *testing.T) {
+	server, err := NewUDPEndpoint("127.0.0.1:0", "", DefaultUDPMTU)
+	if err != nil {
+		t.Fatalf("Failed to create server endpoint: %v", err)
+	}
+	defer server.Close()
+
+	client, err := NewUDPEndpoint("127.0.0.1:0", server.LocalAddr().String(), DefaultUDPMTU)
+	if err != nil {
+		t.Fatalf("Failed to create client endpoint: %v", err)
+	}
+	defer client.Close()
+
+	// The server does not know its peer yet
+	if err := server.WritePacket([]byte("too early")); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+
+	toServer := make(chan string, 1)
+	toClient := make(chan string, 1)
+	server.SetDeliver(func(pkt []byte) { toServer <- string(pkt) })
+	client.SetDeliver(func(pkt []byte) { toClient <- string(pkt) })
+
+	if err := client.WritePacket([]byte("ping")); err != nil {
+		t.Fatalf("Client WritePacket failed: %v", err)
+	}
+	select {
+	case got := <-toServer:
+		if got != "ping" {
+			t.Errorf("Expected ping, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for ping")
+	}
+
+	// The server learned the client's address from the first datagram
+	if err := server.WritePacket([]byte("pong")); err != nil {
+		t.Fatalf("Server WritePacket failed: %v", err)
+	}
+	select {
+	case got := <-toClient:
+		if got != "pong" {
+			t.Errorf("Expected pong, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for pong")
+	}
+
+	if err := client.WritePacket(make([]byte, DefaultUDPMTU+1)); !errors.Is(err, ErrPacketTooBig) {
+		t.Errorf("Expected ErrPacketTooBig, got %v", err)
+	}
+
+	client.Close()
+	if err := client.WritePacket([]byte("closed")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ProtocolTCP is the IP protocol number assigned to TCP
+const ProtocolTCP = 6
+
+// Errors returned by the checksum functions
+var (
+	ErrBadChecksum    = errors.New("packet: TCP checksum mismatch")
+	ErrAddressFamily  = errors.New("packet: source and destination address families differ")
+	ErrInvalidAddress = errors.New("packet: invalid IP address")
+)
+
+// InternetChecksum computes the 16-bit one's complement checksum of b
+// (RFC 1071). initial is a partial sum to continue from, e.g. a pseudo-header.
+func InternetChecksum(b []byte, initial uint32) uint16 {
+	sum := initial
+	for len(b) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8 // Pad the odd byte with zero
+	}
+
+	// Fold the carries back into the lower 16 bits
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// PseudoHeaderSum returns the partial checksum of the TCP/UDP pseudo-header.
+// An IPv4 pseudo-header (RFC 793) is used when both addresses are IPv4,
+// otherwise the IPv6 layout (RFC 8200 section 8.1) is used.
+func PseudoHeaderSum(src, dst net.IP, proto uint8, length int) (uint32, error) {
+	var pseudo []byte
+
+	src4, dst4 := src.To4(), dst.To4()
+	switch {
+	case src4 != nil && dst4 != nil:
+		// src(4) | dst(4) | zero(1) | protocol(1) | TCP length(2)
+		pseudo = make([]byte, 12)
+		copy(pseudo[0:4], src4)
+		copy(pseudo[4:8], dst4)
+		pseudo[9] = proto
+		binary.BigEndian.PutUint16(pseudo[10:12], uint16(length))
+	case src4 != nil || dst4 != nil:
+		return 0, ErrAddressFamily
+	case len(src) == net.IPv6len && len(dst) == net.IPv6len:
+		// src(16) | dst(16) | upper-layer length(4) | zero(3) | next header(1)
+		pseudo = make([]byte, 40)
+		copy(pseudo[0:16], src)
+		copy(pseudo[16:32], dst)
+		binary.BigEndian.PutUint32(pseudo[32:36], uint32(length))
+		pseudo[39] = proto
+	default:
+		return 0, fmt.Errorf("%w: %v -> %v", ErrInvalidAddress, src, dst)
+	}
+
+	var sum uint32
+	for i := 0; i < len(pseudo); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(pseudo[i:]))
+	}
+	return sum, nil
+}
+
+// ComputeChecksum calculates the TCP checksum over the pseudo-header,
+// the header and the payload. The current Checksum field is ignored.
+func (h *TCPHeader) ComputeChecksum(src, dst net.IP, payload []byte) (uint16, error) {
+	saved := h.Checksum
+	h.Checksum = 0
+	b, err := h.Marshal()
+	h.Checksum = saved
+	if err != nil {
+		return 0, err
+	}
+
+	sum, err := PseudoHeaderSum(src, dst, ProtocolTCP, len(b)+len(payload))
+	if err != nil {
+		return 0, err
+	}
+	return InternetChecksum(append(b, payload...), sum), nil
+}
+
+// SetChecksum computes the checksum and stores it in the header
+func (h *TCPHeader) SetChecksum(src, dst net.IP, payload []byte) error {
+	checksum, err := h.ComputeChecksum(src, dst, payload)
+	if err != nil {
+		return err
+	}
+	h.Checksum = checksum
+	return nil
+}
+
+// VerifyChecksum checks the Checksum field against the header as Marshal
+// encodes it. Options that arrived in a different encoding (e.g. EOL
+// followed by padding) change the sum, so segments received from the
+// network should be checked with VerifySegment instead.
+func (h *TCPHeader) VerifyChecksum(src, dst net.IP, payload []byte) error {
+	checksum, err := h.ComputeChecksum(src, dst, payload)
+	if err != nil {
+		return err
+	}
+	if checksum != h.Checksum {
+		return fmt.Errorf("%w: got %#04x, want %#04x", ErrBadChecksum, h.Checksum, checksum)
+	}
+	return nil
+}
+
+// VerifySegment checks the checksum of a TCP segment, header and payload,
+// exactly as it was received
+func VerifySegment(src, dst net.IP, segment []byte) error {
+	if len(segment) < MinHeaderLength {
+		return fmt.Errorf("%w: %d bytes", ErrTruncated, len(segment))
+	}
+	sum, err := PseudoHeaderSum(src, dst, ProtocolTCP, len(segment))
+	if err != nil {
+		return err
+	}
+	// 正しいセグメントは擬似ヘッダーと合わせた総和がゼロになる
+	if InternetChecksum(segment, sum) != 0 {
+		return fmt.Errorf("%w: checksum field %#04x", ErrBadChecksum, binary.BigEndian.Uint16(segment[16:18]))
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package packet
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestInternetChecksum(t *testing.T) {
+	// Example from RFC 1071 section 3: the sum is 0xddf2
+	data := []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}
+	if got := InternetChecksum(data, 0); got != ^uint16(0xddf2) {
+		t.Errorf("Expected checksum %#04x, got %#04x", ^uint16(0xddf2), got)
+	}
+
+	// Odd length input is padded with a zero byte
+	if got, want := InternetChecksum([]byte{0x12, 0x34, 0x56}, 0), InternetChecksum([]byte{0x12, 0x34, 0x56, 0x00}, 0); got != want {
+		t.Errorf("Expected odd-length checksum %#04x, got %#04x", want, got)
+	}
+}
+
+func TestTCPChecksumIPv4(t *testing.T) {
+	src := net.IPv4(192, 168, 0, 1)
+	dst := net.IPv4(192, 168, 0, 2)
+	payload := []byte("checksum me")
+
+	header := NewTCPHeader(12345, 80)
+	header.SequenceNumber = 1000
+	header.SetFlag(FlagACK | FlagPSH)
+
+	if err := header.SetChecksum(src, dst, payload); err != nil {
+		t.Fatalf("SetChecksum failed: %v", err)
+	}
+	if err := header.VerifyChecksum(src, dst, payload); err != nil {
+		t.Fatalf("VerifyChecksum failed: %v", err)
+	}
+
+	// Summing the pseudo-header and the whole segment must give zero
+	b, _ := header.Marshal()
+	sum, _ := PseudoHeaderSum(src, dst, ProtocolTCP, len(b)+len(payload))
+	if got := InternetChecksum(append(b, payload...), sum); got != 0 {
+		t.Errorf("Expected zero checksum over verified segment, got %#04x", got)
+	}
+
+	// Any corruption must be detected
+	corrupted := []byte("checksum mE")
+	if err := header.VerifyChecksum(src, dst, corrupted); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("Expected ErrBadChecksum for corrupted payload, got %v", err)
+	}
+	if err := header.VerifyChecksum(src, net.IPv4(192, 168, 0, 3), payload); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("Expected ErrBadChecksum for wrong destination, got %v", err)
+	}
+}
+
+func TestTCPChecksumIPv6(t *testing.T) {
+	src := net.ParseIP("2001:db8::1")
+	dst := net.ParseIP("2001:db8::2")
+	payload := []byte("v6")
+
+	header := NewTCPHeader(443, 50000)
+	header.SetFlag(FlagSYN)
+
+	if err := header.SetChecksum(src, dst, payload); err != nil {
+		t.Fatalf("SetChecksum failed: %v", err)
+	}
+	if err := header.VerifyChecksum(src, dst, payload); err != nil {
+		t.Fatalf("VerifyChecksum failed: %v", err)
+	}
+
+	// The IPv6 pseudo-header differs from the IPv4 one
+	v4sum, _ := PseudoHeaderSum(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), ProtocolTCP, 20)
+	v6sum, _ := PseudoHeaderSum(src, dst, ProtocolTCP, 20)
+	if v4sum == v6sum {
+		t.Error("Expected different pseudo-header sums for IPv4 and IPv6")
+	}
+}
+
+func TestPseudoHeaderSumErrors(t *testing.T) {
+	if _, err := PseudoHeaderSum(net.IPv4(10, 0, 0, 1), net.ParseIP("2001:db8::1"), ProtocolTCP, 20); !errors.Is(err, ErrAddressFamily) {
+		t.Errorf("Expected ErrAddressFamily, got %v", err)
+	}
+	if _, err := PseudoHeaderSum(nil, nil, ProtocolTCP, 20); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("Expected ErrInvalidAddress, got %v", err)
+	}
+}
+
+// paddedSegment encodes a SYN whose options are MSS and EOL followed by
+// more zero padding than needed (data offset 8 instead of 7), with a
+// checksum computed over those bytes
+func paddedSegment(t *testing.T, src, dst net.IP) []byte {
+	t.Helper()
+
+	header := NewTCPHeader(40000, 80)
+	header.SetFlag(FlagSYN)
+	header.AddOption(MSSOption{MSS: 1460})
+	header.AddOption(EOLOption{})
+	b, err := header.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	b = append(b, 0, 0, 0, 0)
+	b[12] = 8<<4 | b[12]&0x0f
+
+	sum, err := PseudoHeaderSum(src, dst, ProtocolTCP, len(b))
+	if err != nil {
+		t.Fatalf("PseudoHeaderSum failed: %v", err)
+	}
+	checksum := InternetChecksum(b, sum)
+	b[16], b[17] = byte(checksum>>8), byte(checksum)
+	return b
+}
+
+func TestVerifySegmentWithPaddedOptions(t *testing.T) {
+	src := net.IPv4(192, 168, 0, 1)
+	dst := net.IPv4(192, 168, 0, 2)
+	b := paddedSegment(t, src, dst)
+
+	if err := VerifySegment(src, dst, b); err != nil {
+		t.Errorf("Expected padded segment to verify, got %v", err)
+	}
+
+	header, _, err := Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if _, err := header.Marshal(); err != nil || header.DataOffset != 8 {
+		t.Errorf("Expected Marshal to keep data offset 8, got %d (%v)", header.DataOffset, err)
+	}
+
+	// Corruption anywhere in the raw bytes is still detected
+	b[len(b)-1] ^= 0x01
+	if err := VerifySegment(src, dst, b); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("Expected ErrBadChecksum for corrupted padding, got %v", err)
+	}
+	if err := VerifySegment(src, dst, b[:MinHeaderLength-1]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+}
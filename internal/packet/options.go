@@ -0,0 +1,240 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// TCP option kinds
+// See https://www.iana.org/assignments/tcp-parameters
+const (
+	OptionKindEOL           = 0 // End of option list (RFC 793)
+	OptionKindNOP           = 1 // No operation (RFC 793)
+	OptionKindMSS           = 2 // Maximum segment size (RFC 793)
+	OptionKindWindowScale   = 3 // Window scale (RFC 7323)
+	OptionKindSACKPermitted = 4 // SACK permitted (RFC 2018)
+	OptionKindSACK          = 5 // Selective acknowledgment (RFC 2018)
+	OptionKindTimestamps    = 8 // Timestamps (RFC 7323)
+)
+
+// MaxOptionsLength is the maximum length of the options area in bytes
+const MaxOptionsLength = 40
+
+// Errors returned when decoding or encoding TCP options
+var (
+	ErrMalformedOption = errors.New("packet: malformed TCP option")
+	ErrOptionsTooLong  = errors.New("packet: TCP options exceed 40 bytes")
+)
+
+// Option is a single TCP option
+type Option interface {
+	Kind() uint8
+	Len() int // Encoded length in bytes
+	encode(b []byte)
+}
+
+// EOLOption marks the end of the option list
+type EOLOption struct{}
+
+// NOPOption is used to align following options
+type NOPOption struct{}
+
+// MSSOption advertises the maximum segment size (SYN only)
+type MSSOption struct {
+	MSS uint16
+}
+
+// WindowScaleOption advertises the window shift count (SYN only)
+type WindowScaleOption struct {
+	Shift uint8
+}
+
+// SACKPermittedOption announces that SACK may be used (SYN only)
+type SACKPermittedOption struct{}
+
+// SACKBlock is a contiguous block of received data [Left, Right)
+type SACKBlock struct {
+	Left  uint32
+	Right uint32
+}
+
+// SACKOption reports blocks received out of order
+type SACKOption struct {
+	Blocks []SACKBlock
+}
+
+// TimestampsOption carries TSval and TSecr
+type TimestampsOption struct {
+	Value     uint32 // TSval
+	EchoReply uint32 // TSecr
+}
+
+// UnknownOption keeps an option this package does not understand
+type UnknownOption struct {
+	Code uint8
+	Data []byte
+}
+
+func (EOLOption) Kind() uint8           { return OptionKindEOL }
+func (NOPOption) Kind() uint8           { return OptionKindNOP }
+func (MSSOption) Kind() uint8           { return OptionKindMSS }
+func (WindowScaleOption) Kind() uint8   { return OptionKindWindowScale }
+func (SACKPermittedOption) Kind() uint8 { return OptionKindSACKPermitted }
+func (SACKOption) Kind() uint8          { return OptionKindSACK }
+func (TimestampsOption) Kind() uint8    { return OptionKindTimestamps }
+func (o UnknownOption) Kind() uint8     { return o.Code }
+
+func (EOLOption) Len() int           { return 1 }
+func (NOPOption) Len() int           { return 1 }
+func (MSSOption) Len() int           { return 4 }
+func (WindowScaleOption) Len() int   { return 3 }
+func (SACKPermittedOption) Len() int { return 2 }
+func (o SACKOption) Len() int        { return 2 + 8*len(o.Blocks) }
+func (TimestampsOption) Len() int    { return 10 }
+func (o UnknownOption) Len() int     { return 2 + len(o.Data) }
+
+func (EOLOption) encode(b []byte) { b[0] = OptionKindEOL }
+func (NOPOption) encode(b []byte) { b[0] = OptionKindNOP }
+
+func (o MSSOption) encode(b []byte) {
+	b[0], b[1] = OptionKindMSS, 4
+	binary.BigEndian.PutUint16(b[2:4], o.MSS)
+}
+
+func (o WindowScaleOption) encode(b []byte) {
+	b[0], b[1], b[2] = OptionKindWindowScale, 3, o.Shift
+}
+
+func (SACKPermittedOption) encode(b []byte) {
+	b[0], b[1] = OptionKindSACKPermitted, 2
+}
+
+func (o SACKOption) encode(b []byte) {
+	b[0], b[1] = OptionKindSACK, byte(o.Len())
+	for i, block := range o.Blocks {
+		binary.BigEndian.PutUint32(b[2+8*i:], block.Left)
+		binary.BigEndian.PutUint32(b[6+8*i:], block.Right)
+	}
+}
+
+func (o TimestampsOption) encode(b []byte) {
+	b[0], b[1] = OptionKindTimestamps, 10
+	binary.BigEndian.PutUint32(b[2:6], o.Value)
+	binary.BigEndian.PutUint32(b[6:10], o.EchoReply)
+}
+
+func (o UnknownOption) encode(b []byte) {
+	b[0], b[1] = o.Code, byte(o.Len())
+	copy(b[2:], o.Data)
+}
+
+// optionsLength returns the length of the encoded options padded to 32 bits
+func optionsLength(opts []Option) int {
+	n := 0
+	for _, opt := range opts {
+		n += opt.Len()
+	}
+	return (n + 3) &^ 3
+}
+
+// encodeOptions encodes the options and pads them with zeros to a 32-bit boundary
+func encodeOptions(opts []Option) ([]byte, error) {
+	b := make([]byte, optionsLength(opts))
+	if len(b) > MaxOptionsLength {
+		return nil, fmt.Errorf("%w: %d bytes", ErrOptionsTooLong, len(b))
+	}
+
+	off := 0
+	for _, opt := range opts {
+		opt.encode(b[off:])
+		off += opt.Len()
+	}
+	return b, nil
+}
+
+// parseOptions decodes the options area of a TCP header
+func parseOptions(b []byte) ([]Option, error) {
+	var opts []Option
+
+	for len(b) > 0 {
+		kind := b[0]
+		switch kind {
+		case OptionKindEOL:
+			// Everything after EOL is padding
+			return append(opts, EOLOption{}), nil
+		case OptionKindNOP:
+			opts = append(opts, NOPOption{})
+			b = b[1:]
+			continue
+		}
+
+		if len(b) < 2 || int(b[1]) < 2 || int(b[1]) > len(b) {
+			return nil, fmt.Errorf("%w: kind %d has invalid length", ErrMalformedOption, kind)
+		}
+		length := int(b[1])
+		data := b[2:length]
+
+		var opt Option
+		switch kind {
+		case OptionKindMSS:
+			if length != 4 {
+				return nil, fmt.Errorf("%w: MSS length %d", ErrMalformedOption, length)
+			}
+			opt = MSSOption{MSS: binary.BigEndian.Uint16(data)}
+		case OptionKindWindowScale:
+			if length != 3 {
+				return nil, fmt.Errorf("%w: window scale length %d", ErrMalformedOption, length)
+			}
+			opt = WindowScaleOption{Shift: data[0]}
+		case OptionKindSACKPermitted:
+			if length != 2 {
+				return nil, fmt.Errorf("%w: SACK-permitted length %d", ErrMalformedOption, length)
+			}
+			opt = SACKPermittedOption{}
+		case OptionKindSACK:
+			if (length-2)%8 != 0 || length == 2 {
+				return nil, fmt.Errorf("%w: SACK length %d", ErrMalformedOption, length)
+			}
+			sack := SACKOption{}
+			for i := 0; i < len(data); i += 8 {
+				sack.Blocks = append(sack.Blocks, SACKBlock{
+					Left:  binary.BigEndian.Uint32(data[i:]),
+					Right: binary.BigEndian.Uint32(data[i+4:]),
+				})
+			}
+			opt = sack
+		case OptionKindTimestamps:
+			if length != 10 {
+				return nil, fmt.Errorf("%w: timestamps length %d", ErrMalformedOption, length)
+			}
+			opt = TimestampsOption{
+				Value:     binary.BigEndian.Uint32(data[0:4]),
+				EchoReply: binary.BigEndian.Uint32(data[4:8]),
+			}
+		default:
+			opt = UnknownOption{Code: kind, Data: append([]byte(nil), data...)}
+		}
+
+		opts = append(opts, opt)
+		b = b[length:]
+	}
+
+	return opts, nil
+}
+
+// AddOption appends an option and updates DataOffset accordingly
+func (h *TCPHeader) AddOption(opt Option) {
+	h.Options = append(h.Options, opt)
+	h.DataOffset = uint8((MinHeaderLength + optionsLength(h.Options)) / 4)
+}
+
+// FindOption returns the first option of the given kind, or nil
+func (h *TCPHeader) FindOption(kind uint8) Option {
+	for _, opt := range h.Options {
+		if opt.Kind() == kind {
+			return opt
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package packet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestOptionsRoundTrip(t *testing.T) {
+	header := NewTCPHeader(8080, 80)
+	header.SetFlag(FlagSYN)
+	header.AddOption(MSSOption{MSS: 1460})
+	header.AddOption(SACKPermittedOption{})
+	header.AddOption(TimestampsOption{Value: 100, EchoReply: 0})
+	header.AddOption(NOPOption{})
+	header.AddOption(WindowScaleOption{Shift: 7})
+
+	// 4 + 2 + 10 + 1 + 3 = 20 bytes of options
+	if header.DataOffset != 10 {
+		t.Errorf("Expected data offset 10, got %d", header.DataOffset)
+	}
+
+	b, err := header.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if len(b) != 40 {
+		t.Fatalf("Expected 40 bytes, got %d", len(b))
+	}
+
+	decoded, payload, err := Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if len(payload) != 0 {
+		t.Errorf("Expected empty payload, got %d bytes", len(payload))
+	}
+	if !reflect.DeepEqual(decoded.Options, header.Options) {
+		t.Errorf("Options mismatch:\n got  %#v\n want %#v", decoded.Options, header.Options)
+	}
+
+	mss, ok := decoded.FindOption(OptionKindMSS).(MSSOption)
+	if !ok || mss.MSS != 1460 {
+		t.Errorf("Expected MSS 1460, got %#v", decoded.FindOption(OptionKindMSS))
+	}
+	if decoded.FindOption(OptionKindSACK) != nil {
+		t.Error("Expected no SACK option")
+	}
+}
+
+func TestOptionsPadding(t *testing.T) {
+	header := NewTCPHeader(1, 2)
+	header.AddOption(WindowScaleOption{Shift: 3})
+
+	// 3 bytes of options are padded to 4
+	if header.DataOffset != 6 {
+		t.Errorf("Expected data offset 6, got %d", header.DataOffset)
+	}
+
+	b, err := header.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if len(b) != 24 || b[23] != OptionKindEOL {
+		t.Fatalf("Expected 24 bytes ending with zero padding, got %v", b)
+	}
+
+	decoded, _, err := Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want := []Option{WindowScaleOption{Shift: 3}, EOLOption{}}
+	if !reflect.DeepEqual(decoded.Options, want) {
+		t.Errorf("Expected %#v, got %#v", want, decoded.Options)
+	}
+}
+
+func TestSACKAndUnknownOptions(t *testing.T) {
+	header := NewTCPHeader(1, 2)
+	header.AddOption(NOPOption{})
+	header.AddOption(NOPOption{})
+	header.AddOption(SACKOption{Blocks: []SACKBlock{{Left: 100, Right: 200}, {Left: 300, Right: 400}}})
+	header.AddOption(UnknownOption{Code: 254, Data: []byte{0xab, 0xcd}})
+
+	b, err := header.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	decoded, _, err := Unmarshal(append(b, "data"...))
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded.Options, header.Options) {
+		t.Errorf("Options mismatch:\n got  %#v\n want %#v", decoded.Options, header.Options)
+	}
+}
+
+func TestOptionsErrors(t *testing.T) {
+	// Too many options
+	header := NewTCPHeader(1, 2)
+	for i := 0; i < 5; i++ {
+		header.AddOption(TimestampsOption{})
+	}
+	if _, err := header.Marshal(); !errors.Is(err, ErrOptionsTooLong) {
+		t.Errorf("Expected ErrOptionsTooLong, got %v", err)
+	}
+
+	base, _ := NewTCPHeader(1, 2).Marshal()
+	malformed := [][]byte{
+		{OptionKindNOP, OptionKindNOP, OptionKindNOP, OptionKindMSS}, // Missing length byte
+		{OptionKindMSS, 3, 0x05, 0x00},                               // Wrong MSS length
+		{OptionKindNOP, 0x20, 0x01, 0x00},                            // Unknown kind with length beyond the header
+		{OptionKindSACK, 6, 0, 0, 0, 0, 0, 0},
+	}
+	for _, opts := range malformed {
+		padded := append(opts, make([]byte, (4-len(opts)%4)%4)...)
+		b := append(append([]byte(nil), base...), padded...)
+		b[12] = byte((20+len(padded))/4) << 4
+		if _, _, err := Unmarshal(b); !errors.Is(err, ErrMalformedOption) {
+			t.Errorf("Options %v: expected ErrMalformedOption, got %v", opts, err)
+		}
+	}
+}
@@ -2,22 +2,34 @@
 package packet
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
+// MinHeaderLength is the length of a TCP header without options
+const MinHeaderLength = 20
+
+// Errors returned when decoding or encoding a TCP header
+var (
+	ErrTruncated         = errors.New("packet: truncated TCP segment")
+	ErrInvalidDataOffset = errors.New("packet: invalid TCP data offset")
+)
+
 // TCPHeader represents the TCP header structure
 // Based on RFC 793: https://tools.ietf.org/html/rfc793
 type TCPHeader struct {
-	SourcePort      uint16 // Source port number
-	DestinationPort uint16 // Destination port number
-	SequenceNumber  uint32 // Sequence number
-	AckNumber       uint32 // Acknowledgment number
-	DataOffset      uint8  // Data offset (header length in 32-bit words)
-	Reserved        uint8  // Reserved (must be zero)
-	Flags           uint8  // Control flags (URG, ACK, PSH, RST, SYN, FIN)
-	WindowSize      uint16 // Window size
-	Checksum        uint16 // Checksum
-	UrgentPointer   uint16 // Urgent pointer
+	SourcePort      uint16   // Source port number
+	DestinationPort uint16   // Destination port number
+	SequenceNumber  uint32   // Sequence number
+	AckNumber       uint32   // Acknowledgment number
+	DataOffset      uint8    // Data offset (header length in 32-bit words)
+	Reserved        uint8    // Reserved (must be zero)
+	Flags           uint8    // Control flags (URG, ACK, PSH, RST, SYN, FIN)
+	WindowSize      uint16   // Window size
+	Checksum        uint16   // Checksum
+	UrgentPointer   uint16   // Urgent pointer
+	Options         []Option // TCP options (DataOffset follows their length)
 }
 
 // TCP Control Flags
@@ -55,6 +67,71 @@ func (h *TCPHeader) HeaderLength() int {
 	return int(h.DataOffset) * 4
 }
 
+// Marshal encodes the header into its RFC 793 wire format.
+// The data offset on the wire is derived from the options, which are
+// padded to a 32-bit boundary; the header itself is not modified.
+// The Checksum field is written as is.
+func (h *TCPHeader) Marshal() ([]byte, error) {
+	opts, err := encodeOptions(h.Options)
+	if err != nil {
+		return nil, err
+	}
+	dataOffset := uint8((MinHeaderLength + len(opts)) / 4)
+
+	b := make([]byte, MinHeaderLength+len(opts))
+	binary.BigEndian.PutUint16(b[0:2], h.SourcePort)
+	binary.BigEndian.PutUint16(b[2:4], h.DestinationPort)
+	binary.BigEndian.PutUint32(b[4:8], h.SequenceNumber)
+	binary.BigEndian.PutUint32(b[8:12], h.AckNumber)
+	// Data offset (4 bits) | Reserved (6 bits) | Flags (6 bits)
+	b[12] = dataOffset<<4 | (h.Reserved>>2)&0x0f
+	b[13] = (h.Reserved&0x03)<<6 | h.Flags&0x3f
+	binary.BigEndian.PutUint16(b[14:16], h.WindowSize)
+	binary.BigEndian.PutUint16(b[16:18], h.Checksum)
+	binary.BigEndian.PutUint16(b[18:20], h.UrgentPointer)
+	copy(b[MinHeaderLength:], opts)
+
+	return b, nil
+}
+
+// Unmarshal decodes a TCP segment and returns its header and payload.
+// The returned payload shares memory with b.
+func Unmarshal(b []byte) (*TCPHeader, []byte, error) {
+	if len(b) < MinHeaderLength {
+		return nil, nil, fmt.Errorf("%w: %d bytes", ErrTruncated, len(b))
+	}
+
+	h := &TCPHeader{
+		SourcePort:      binary.BigEndian.Uint16(b[0:2]),
+		DestinationPort: binary.BigEndian.Uint16(b[2:4]),
+		SequenceNumber:  binary.BigEndian.Uint32(b[4:8]),
+		AckNumber:       binary.BigEndian.Uint32(b[8:12]),
+		DataOffset:      b[12] >> 4,
+		Reserved:        (b[12]&0x0f)<<2 | b[13]>>6,
+		Flags:           b[13] & 0x3f,
+		WindowSize:      binary.BigEndian.Uint16(b[14:16]),
+		Checksum:        binary.BigEndian.Uint16(b[16:18]),
+		UrgentPointer:   binary.BigEndian.Uint16(b[18:20]),
+	}
+
+	headerLen := h.HeaderLength()
+	if headerLen < MinHeaderLength {
+		return nil, nil, fmt.Errorf("%w: %d", ErrInvalidDataOffset, h.DataOffset)
+	}
+	if headerLen > len(b) {
+		return nil, nil, fmt.Errorf("%w: header length %d exceeds %d bytes",
+			ErrTruncated, headerLen, len(b))
+	}
+
+	opts, err := parseOptions(b[MinHeaderLength:headerLen])
+	if err != nil {
+		return nil, nil, err
+	}
+	h.Options = opts
+
+	return h, b[headerLen:], nil
+}
+
 // String returns a string representation of the TCP header
 func (h *TCPHeader) String() string {
 	flags := ""
@@ -1,6 +1,8 @@
 package packet
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -64,3 +66,94 @@ func TestTCPHeaderString(t *testing.T) {
 		t.Error("String representation should not be empty")
 	}
 }
+
+func TestTCPHeaderMarshalUnmarshal(t *testing.T) {
+	header := NewTCPHeader(8080, 80)
+	header.SequenceNumber = 0x01020304
+	header.AckNumber = 0xa0b0c0d0
+	header.SetFlag(FlagSYN | FlagACK | FlagURG)
+	header.WindowSize = 4096
+	header.Checksum = 0xbeef
+	header.UrgentPointer = 7
+
+	b, err := header.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if len(b) != 20 {
+		t.Fatalf("Expected 20 bytes, got %d", len(b))
+	}
+	// Data offset 5, reserved 0, flags URG|ACK|SYN
+	if b[12] != 0x50 || b[13] != 0x32 {
+		t.Errorf("Unexpected offset/flags bytes: %#02x %#02x", b[12], b[13])
+	}
+
+	payload := []byte("hello")
+	decoded, data, err := Unmarshal(append(b, payload...))
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, header) {
+		t.Errorf("Round trip mismatch:\n got  %+v\n want %+v", decoded, header)
+	}
+	if string(data) != "hello" {
+		t.Errorf("Expected payload %q, got %q", "hello", string(data))
+	}
+}
+
+func TestTCPHeaderReservedBits(t *testing.T) {
+	header := NewTCPHeader(1, 2)
+	header.Reserved = 0x3f
+	header.Flags = FlagFIN
+
+	b, err := header.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if b[12] != 0x5f || b[13] != 0xc1 {
+		t.Errorf("Unexpected offset/flags bytes: %#02x %#02x", b[12], b[13])
+	}
+
+	decoded, _, err := Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.Reserved != 0x3f || decoded.Flags != FlagFIN {
+		t.Errorf("Expected reserved 0x3f and FIN, got %#x and %#x", decoded.Reserved, decoded.Flags)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	valid, _ := NewTCPHeader(1, 2).Marshal()
+
+	// Shorter than the fixed header
+	if _, _, err := Unmarshal(valid[:19]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+
+	// Data offset smaller than 5 words
+	bad := append([]byte(nil), valid...)
+	bad[12] = 4 << 4
+	if _, _, err := Unmarshal(bad); !errors.Is(err, ErrInvalidDataOffset) {
+		t.Errorf("Expected ErrInvalidDataOffset, got %v", err)
+	}
+
+	// Data offset pointing past the end of the buffer
+	bad[12] = 6 << 4
+	if _, _, err := Unmarshal(bad); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+}
+
+func TestMarshalRecomputesDataOffset(t *testing.T) {
+	header := NewTCPHeader(1, 2)
+	header.DataOffset = 4
+	b, err := header.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	// The wire offset follows the options, the header is left untouched
+	if header.DataOffset != 4 || b[12]>>4 != 5 {
+		t.Errorf("Expected data offset 4 (wire 5), got %d (wire %d)", header.DataOffset, b[12]>>4)
+	}
+}
@@ -0,0 +1,70 @@
+package socket
+
+import (
+	"sync"
+	"time"
+)
+
+// deadline is a read or write deadline of a socket.
+// The channel returned by wait is closed when the deadline expires, which
+// also wakes up calls that are already blocked.
+type deadline struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	expired chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{expired: make(chan struct{})}
+}
+
+// set sets the deadline; the zero time means no deadline
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// 発火済みのタイマーはチャネルを閉じ終えるまで待つ
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.expired
+	}
+	d.timer = nil
+
+	closed := isClosed(d.expired)
+	if t.IsZero() {
+		if closed {
+			d.expired = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.expired = make(chan struct{})
+		}
+		expired := d.expired
+		d.timer = time.AfterFunc(dur, func() {
+			close(expired)
+		})
+		return
+	}
+
+	if !closed {
+		close(d.expired)
+	}
+}
+
+// wait returns a channel that is closed when the deadline expires
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.expired
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -2,11 +2,18 @@
 package socket
 
 import (
+	"context"
 	"errors"
+	"io"
 	"net"
+	"os"
 	"sync"
+	"time"
 )
 
+// receiveChunkSize is the largest amount of data returned by one Receive
+const receiveChunkSize = 64 * 1024
+
 // SocketState represents the state of a TCP socket
 type SocketState int
 
@@ -52,16 +59,60 @@ type TinySocket struct {
 	sendAck      uint32
 	recvAck      uint32
 	
-	// Buffers
-	sendBuffer   []byte
-	recvBuffer   []byte
-	
 	// Channels for communication
 	acceptChan   chan *TinySocket
 	closeChan    chan struct{}
 	
+	// Deadlines
+	readDeadline  *deadline // Receive and Accept
+	writeDeadline *deadline // Send
+	
 	// Connection management
 	parent       *TinySocket // For accepted connections
+	transport    Transport   // TCP engine used to open connections
+	conn         Conn        // Underlying connection once connected
+	listener     Listener    // Underlying listener once listening
+}
+
+// Transport is the TCP engine behind sockets (implemented by tcp.Stack)
+type Transport interface {
+	// Dial performs an active open and blocks until the connection is
+	// established, refused, timed out or ctx is done.
+	// A nil or unspecified local address is chosen by the transport.
+	Dial(ctx context.Context, local, remote *net.TCPAddr) (Conn, error)
+	// Listen starts accepting connections on local. backlog bounds the
+	// connections waiting for the handshake and for Accept; 0 means the
+	// transport's default.
+	Listen(local *net.TCPAddr, backlog int) (Listener, error)
+}
+
+// Listener accepts connections for a Transport
+type Listener interface {
+	// Accept returns the next connection whose handshake has completed
+	Accept(ctx context.Context) (Conn, error)
+	Addr() *net.TCPAddr
+	Close() error
+}
+
+// Conn is a connection opened by a Transport
+type Conn interface {
+	// Send queues data for transmission, blocking while the send buffer is
+	// full. It returns the number of bytes queued.
+	Send(ctx context.Context, data []byte) (int, error)
+	// Receive reads in-order data into b, blocking until some is available.
+	// It returns io.EOF after the peer's FIN once all data has been read.
+	Receive(ctx context.Context, b []byte) (int, error)
+	State() SocketState
+	LocalAddr() *net.TCPAddr
+	RemoteAddr() *net.TCPAddr
+	// Close sends FIN (or drops a connection that is not yet established)
+	Close() error
+	// CloseWrite sends FIN but keeps receiving until the peer's FIN
+	CloseWrite() error
+	// CloseRead discards received data; Receive returns io.EOF afterwards
+	CloseRead() error
+	// Abort sends RST instead of FIN and discards unsent data
+	Abort() error
 }
 
 // SocketAPI defines the interface for socket operations
@@ -80,19 +131,30 @@ type SocketAPI interface {
 // NewSocket creates a new TinySocket
 func NewSocket() *TinySocket {
 	return &TinySocket{
-		state:       StateClosed,
-		connections: make(map[string]*TinySocket),
-		acceptChan:  make(chan *TinySocket, 10),
-		closeChan:   make(chan struct{}),
-		sendBuffer:  make([]byte, 0, 4096),
-		recvBuffer:  make([]byte, 0, 4096),
+		state:         StateClosed,
+		connections:   make(map[string]*TinySocket),
+		acceptChan:    make(chan *TinySocket, 10),
+		closeChan:     make(chan struct{}),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
 	}
 }
 
+// NewSocketWithTransport creates a new TinySocket that opens connections
+// through the given transport
+func NewSocketWithTransport(transport Transport) *TinySocket {
+	s := NewSocket()
+	s.transport = transport
+	return s
+}
+
 // State returns the current state of the socket
 func (s *TinySocket) State() SocketState {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	if s.conn != nil {
+		return s.conn.State()
+	}
 	return s.state
 }
 
@@ -119,6 +181,12 @@ func (s *TinySocket) setState(newState SocketState) {
 
 // Listen starts listening on the specified address
 func (s *TinySocket) Listen(addr string) error {
+	return s.ListenBacklog(addr, 0)
+}
+
+// ListenBacklog starts listening on the specified address with at most
+// backlog pending connections (0 uses the transport's default)
+func (s *TinySocket) ListenBacklog(addr string, backlog int) error {
 	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		return err
@@ -127,6 +195,18 @@ func (s *TinySocket) Listen(addr string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
+	if s.transport != nil {
+		if s.state != StateClosed || s.conn != nil || s.listener != nil {
+			return &net.OpError{Op: "listen", Net: "tcp", Addr: tcpAddr, Err: errors.New("socket already in use")}
+		}
+		listener, err := s.transport.Listen(tcpAddr, backlog)
+		if err != nil {
+			return &net.OpError{Op: "listen", Net: "tcp", Addr: tcpAddr, Err: err}
+		}
+		s.listener = listener
+		tcpAddr = listener.Addr()
+	}
+	
 	s.localAddr = tcpAddr
 	s.state = StateListen
 	s.isListening = true
@@ -136,79 +216,286 @@ func (s *TinySocket) Listen(addr string) error {
 
 // Accept waits for and returns the next connection
 func (s *TinySocket) Accept() (*TinySocket, error) {
+	return s.AcceptContext(context.Background())
+}
+
+// AcceptContext is like Accept but gives up when ctx is done or the read
+// deadline expires
+func (s *TinySocket) AcceptContext(ctx context.Context) (*TinySocket, error) {
+	s.mu.RLock()
+	listener := s.listener
+	addr := s.localAddr
+	s.mu.RUnlock()
+	
+	ctx, cancel, expired := s.callContext(ctx, s.readDeadline)
+	defer cancel()
+	if err := s.checkCall(expired); err != nil {
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: addr, Err: err}
+	}
 	if s.State() != StateListen {
 		return nil, &net.OpError{Op: "accept", Err: errors.New("socket not listening")}
 	}
 	
+	if listener != nil {
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: addr, Err: s.callError(err, expired)}
+		}
+		return s.newChild(conn), nil
+	}
+	
 	// Wait for incoming connection
 	select {
 	case conn := <-s.acceptChan:
 		return conn, nil
-	case <-s.closeChan:
-		return nil, &net.OpError{Op: "accept", Err: errors.New("socket closed")}
+	case <-ctx.Done():
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: addr, Err: s.callError(ctx.Err(), expired)}
 	}
 }
 
+// newChild wraps an accepted connection into a socket owned by s
+func (s *TinySocket) newChild(conn Conn) *TinySocket {
+	child := NewSocketWithTransport(s.transport)
+	child.conn = conn
+	child.state = StateEstablished
+	child.localAddr = conn.LocalAddr()
+	child.remoteAddr = conn.RemoteAddr()
+	child.parent = s
+	
+	s.mu.Lock()
+	s.connections[child.remoteAddr.String()] = child
+	s.mu.Unlock()
+	
+	return child
+}
+
 // Connect establishes a connection to the remote address
 func (s *TinySocket) Connect(addr string) error {
+	return s.DialContext(context.Background(), addr)
+}
+
+// DialContext establishes a connection to the remote address.
+// It blocks until the three-way handshake completes and fails if the peer
+// resets the connection, the SYN retransmissions time out or ctx is done.
+// Timeouts are reported as errors whose Timeout method returns true.
+func (s *TinySocket) DialContext(ctx context.Context, addr string) error {
 	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		return err
 	}
-	
+
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	
+	if s.transport == nil {
+		s.mu.Unlock()
+		return &net.OpError{Op: "dial", Net: "tcp", Addr: tcpAddr, Err: errors.New("socket has no transport")}
+	}
+	if s.state != StateClosed || s.conn != nil {
+		s.mu.Unlock()
+		return &net.OpError{Op: "dial", Net: "tcp", Addr: tcpAddr, Err: errors.New("socket already in use")}
+	}
 	s.remoteAddr = tcpAddr
 	s.state = StateSynSent
-	
-	// TODO: Implement TCP handshake
-	// For now, simulate successful connection
+	localAddr := s.localAddr
+	s.mu.Unlock()
+
+	// ハンドシェイク中はロックを保持しない
+	conn, err := s.transport.Dial(ctx, localAddr, tcpAddr)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.state = StateClosed
+		return &net.OpError{Op: "dial", Net: "tcp", Source: localAddr, Addr: tcpAddr, Err: err}
+	}
+	s.conn = conn
+	s.localAddr = conn.LocalAddr()
 	s.state = StateEstablished
-	
+
 	return nil
 }
 
-// Send sends data through the socket
+// Send sends data through the socket.
+// It blocks until all data has been queued for transmission.
 func (s *TinySocket) Send(data []byte) (int, error) {
-	if s.State() != StateEstablished {
+	return s.SendContext(context.Background(), data)
+}
+
+// SendContext is like Send but gives up when ctx is done or the write
+// deadline expires
+func (s *TinySocket) SendContext(ctx context.Context, data []byte) (int, error) {
+	s.mu.RLock()
+	conn := s.conn
+	s.mu.RUnlock()
+	
+	if conn == nil {
 		return 0, &net.OpError{Op: "send", Err: errors.New("socket not connected")}
 	}
 	
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	
-	// Add to send buffer for now
-	s.sendBuffer = append(s.sendBuffer, data...)
+	ctx, cancel, expired := s.callContext(ctx, s.writeDeadline)
+	defer cancel()
+	if err := s.checkCall(expired); err != nil {
+		return 0, s.opError("send", err)
+	}
 	
-	// TODO: Implement actual TCP sending
-	// For now, simulate successful send
-	return len(data), nil
+	n, err := conn.Send(ctx, data)
+	if err != nil {
+		return n, s.opError("send", s.callError(err, expired))
+	}
+	return n, nil
 }
 
-// Receive receives data from the socket
+// Receive blocks until data arrives and returns it.
+// It returns io.EOF once the peer has closed the connection.
 func (s *TinySocket) Receive() ([]byte, error) {
-	if s.State() != StateEstablished {
-		return nil, &net.OpError{Op: "receive", Err: errors.New("socket not connected")}
+	buf := make([]byte, receiveChunkSize)
+	n, err := s.Read(buf)
+	if err != nil {
+		return nil, err
 	}
+	return buf[:n], nil
+}
+
+// Read reads received data into b like io.Reader.
+// It blocks until data arrives and returns io.EOF once the peer has closed
+// the connection and all data has been read.
+func (s *TinySocket) Read(b []byte) (int, error) {
+	return s.ReadContext(context.Background(), b)
+}
+
+// ReadContext is like Read but gives up when ctx is done or the read
+// deadline expires
+func (s *TinySocket) ReadContext(ctx context.Context, b []byte) (int, error) {
+	s.mu.RLock()
+	conn := s.conn
+	s.mu.RUnlock()
 	
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if conn == nil {
+		return 0, &net.OpError{Op: "receive", Err: errors.New("socket not connected")}
+	}
 	
-	if len(s.recvBuffer) == 0 {
-		return nil, &net.OpError{Op: "receive", Err: errors.New("no data available")}
+	ctx, cancel, expired := s.callContext(ctx, s.readDeadline)
+	defer cancel()
+	if err := s.checkCall(expired); err != nil {
+		return 0, s.opError("receive", err)
 	}
 	
-	// Return all available data for now
-	data := make([]byte, len(s.recvBuffer))
-	copy(data, s.recvBuffer)
-	s.recvBuffer = s.recvBuffer[:0] // Clear buffer
+	n, err := conn.Receive(ctx, b)
+	if err == io.EOF {
+		return n, io.EOF
+	}
+	if err != nil {
+		return n, s.opError("receive", s.callError(err, expired))
+	}
+	return n, nil
+}
+
+// CloseWrite shuts down the sending side of the connection by sending FIN.
+// Data can still be received until the peer closes its side.
+func (s *TinySocket) CloseWrite() error {
+	return s.shutdown(Conn.CloseWrite)
+}
+
+// CloseRead shuts down the receiving side of the connection.
+// Subsequent reads return io.EOF while sending is unaffected.
+func (s *TinySocket) CloseRead() error {
+	return s.shutdown(Conn.CloseRead)
+}
+
+// shutdown applies a half-close to the underlying connection
+func (s *TinySocket) shutdown(close func(Conn) error) error {
+	s.mu.RLock()
+	conn := s.conn
+	s.mu.RUnlock()
 	
-	return data, nil
+	if conn == nil {
+		return &net.OpError{Op: "close", Err: errors.New("socket not connected")}
+	}
+	if isClosed(s.closeChan) {
+		return s.opError("close", net.ErrClosed)
+	}
+	if err := close(conn); err != nil {
+		return s.opError("close", err)
+	}
+	return nil
+}
+
+// SetDeadline sets the read and write deadlines.
+// Blocked calls are woken up when their deadline expires and fail with an
+// error wrapping os.ErrDeadlineExceeded. The zero time means no deadline.
+func (s *TinySocket) SetDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	s.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Receive, Read and Accept calls
+func (s *TinySocket) SetReadDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Send calls
+func (s *TinySocket) SetWriteDeadline(t time.Time) error {
+	s.writeDeadline.set(t)
+	return nil
+}
+
+// callContext returns the context for one blocking call. It is cancelled
+// when ctx is done, the deadline expires or the socket is closed.
+func (s *TinySocket) callContext(ctx context.Context, d *deadline) (context.Context, context.CancelFunc, chan struct{}) {
+	expired := d.wait()
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-expired:
+		case <-s.closeChan:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, cancel, expired
+}
+
+// checkCall fails calls made after Close or after the deadline expired
+func (s *TinySocket) checkCall(expired chan struct{}) error {
+	switch {
+	case isClosed(s.closeChan):
+		return net.ErrClosed
+	case isClosed(expired):
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+// callError reports why a blocking call was interrupted
+func (s *TinySocket) callError(err error, expired chan struct{}) error {
+	if cause := s.checkCall(expired); cause != nil {
+		return cause
+	}
+	return err
+}
+
+// opError wraps a connection error with the socket addresses
+func (s *TinySocket) opError(op string, err error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &net.OpError{Op: op, Net: "tcp", Source: s.localAddr, Addr: s.remoteAddr, Err: err}
 }
 
 // Close closes the socket
 func (s *TinySocket) Close() error {
+	return s.close(Conn.Close)
+}
+
+// Abort closes the socket by resetting the connection: RST is sent instead
+// of FIN and data not yet delivered to the peer is discarded
+func (s *TinySocket) Abort() error {
+	return s.close(Conn.Abort)
+}
+
+// close closes the socket, ending the connection with closeConn
+func (s *TinySocket) close(closeConn func(Conn) error) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
@@ -218,7 +505,8 @@ func (s *TinySocket) Close() error {
 	
 	s.state = StateClosed
 	
-	// Signal closure
+	// Signal closure before closing the connection so that the calls it
+	// wakes up report net.ErrClosed
 	select {
 	case <-s.closeChan:
 		// Already closed
@@ -226,5 +514,30 @@ func (s *TinySocket) Close() error {
 		close(s.closeChan)
 	}
 	
+	if s.listener != nil {
+		if err := s.listener.Close(); err != nil {
+			return err
+		}
+	}
+	if s.conn != nil {
+		if err := closeConn(s.conn); err != nil {
+			return err
+		}
+	}
+	if s.parent != nil {
+		s.parent.removeChild(s)
+	}
+	
 	return nil
 }
+
+// removeChild forgets an accepted connection that has been closed
+func (s *TinySocket) removeChild(child *TinySocket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	key := child.remoteAddr.String()
+	if s.connections[key] == child {
+		delete(s.connections, key)
+	}
+}
@@ -0,0 +1,562 @@
+package tcp
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+	"github.com/sasakihasuto/tinytcp/internal/socket"
+)
+
+// Conn is a connection owned by a Stack
+type Conn struct {
+	stack     *Stack
+	tcb       *TCB
+	handshake *ThreeWayHandshake
+	transfer  *DataTransfer
+	closer    *FourWayHandshake
+	listener  *Listener // パッシブオープンの場合のみ
+	err       error     // 接続が異常終了した理由
+
+	finReceived bool // 相手のFINを受信済み（以降のReceiveはio.EOF）
+	readClosed  bool // CloseReadで受信側を閉じた（以降の受信データは破棄）
+	finPending  bool // 未送信データを送り終えたらFINを送る
+
+	timeWaitUntil time.Time // TIME_WAITを終えて4タプルを解放する時刻
+
+	// Persist timer, running while queued data is blocked by a zero window
+	persistAt      time.Time     // 次にウィンドウプローブを送る時刻（ゼロ値なら停止中）
+	persistBackoff time.Duration // 次のプローブまでの間隔
+	persistProbes  int           // ACKが返らないまま送ったプローブの数
+
+	// cond is signalled whenever the TCB changes
+	cond *sync.Cond
+}
+
+// TCB returns the control block of the connection.
+// It must only be inspected while no segments are being processed.
+func (c *Conn) TCB() *TCB {
+	return c.tcb
+}
+
+// State returns the current state of the connection
+func (c *Conn) State() socket.SocketState {
+	c.stack.mu.Lock()
+	defer c.stack.mu.Unlock()
+	return c.tcb.State
+}
+
+// RTTStats returns the smoothed round-trip time, its variation and the
+// current retransmission timeout. srtt and rttvar are zero until the first
+// RTT sample.
+func (c *Conn) RTTStats() (srtt, rttvar, rto time.Duration) {
+	c.stack.mu.Lock()
+	defer c.stack.mu.Unlock()
+	return c.tcb.SRTT, c.tcb.RTTVAR, c.tcb.RetransmissionTimeout
+}
+
+// LocalAddr returns the local address of the connection
+func (c *Conn) LocalAddr() *net.TCPAddr {
+	return c.tcb.LocalAddr
+}
+
+// RemoteAddr returns the remote address of the connection
+func (c *Conn) RemoteAddr() *net.TCPAddr {
+	return c.tcb.RemoteAddr
+}
+
+// Send queues data for transmission in segments of at most SegmentSize bytes,
+// which are sent as far as the peer's window allows.
+// It blocks while the send buffer is full and returns the number of bytes
+// queued so far if ctx is done or the connection fails.
+func (c *Conn) Send(ctx context.Context, data []byte) (int, error) {
+	c.stack.mu.Lock()
+	defer c.stack.mu.Unlock()
+
+	sent := 0
+	for sent < len(data) {
+		if c.err != nil {
+			return sent, c.err
+		}
+		if !c.closer.CanSendData() || c.finPending {
+			return sent, ErrConnClosed
+		}
+
+		n := c.transfer.Queue(data[sent:])
+		if n == 0 {
+			if err := c.waitLocked(ctx); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		sent += n
+		c.outputDataLocked()
+	}
+	return sent, nil
+}
+
+// Receive reads in-order data into b. It blocks until data is available and
+// returns io.EOF once the peer has closed and all data has been read.
+func (c *Conn) Receive(ctx context.Context, b []byte) (int, error) {
+	c.stack.mu.Lock()
+	defer c.stack.mu.Unlock()
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for {
+		if c.readClosed {
+			return 0, io.EOF
+		}
+		if len(c.tcb.RecvBuffer) > 0 {
+			n := c.transfer.Read(b)
+			c.windowUpdateLocked()
+			return n, nil
+		}
+		if c.finReceived {
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+		if !c.tcb.IsSynchronized() {
+			return 0, ErrConnClosed
+		}
+		if err := c.waitLocked(ctx); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Close sends FIN, or drops the connection if it is not yet established
+func (c *Conn) Close() error {
+	c.stack.mu.Lock()
+	defer c.stack.mu.Unlock()
+
+	switch c.tcb.State {
+	case socket.StateEstablished, socket.StateCloseWait:
+		return c.sendFinLocked()
+	case socket.StateSynSent, socket.StateSynReceived:
+		c.tcb.State = socket.StateClosed
+		c.stack.removeLocked(c)
+	}
+	return nil
+}
+
+// Abort resets the connection: RST is sent instead of FIN and any unsent or
+// unacknowledged data is discarded. Later calls fail with ErrConnClosed.
+func (c *Conn) Abort() error {
+	c.stack.mu.Lock()
+	defer c.stack.mu.Unlock()
+
+	switch c.tcb.State {
+	case socket.StateSynReceived, socket.StateEstablished, socket.StateFinWait1,
+		socket.StateFinWait2, socket.StateCloseWait:
+		c.resetLocked(ErrConnClosed)
+	case socket.StateClosed:
+	default:
+		// SYN_SENTや双方がFINを送った後はRSTを送らずTCBを削除する（RFC 793 3.9 ABORT）
+		c.failLocked(ErrConnClosed)
+	}
+	return nil
+}
+
+// CloseWrite sends FIN but keeps receiving until the peer's FIN.
+// Later calls to Send fail with ErrConnClosed.
+func (c *Conn) CloseWrite() error {
+	c.stack.mu.Lock()
+	defer c.stack.mu.Unlock()
+
+	switch c.tcb.State {
+	case socket.StateEstablished, socket.StateCloseWait:
+		return c.sendFinLocked()
+	case socket.StateSynSent, socket.StateSynReceived:
+		return ErrNotConnected
+	}
+	return nil
+}
+
+// CloseRead shuts down the receiving side. Buffered and later data is
+// discarded and Receive returns io.EOF.
+func (c *Conn) CloseRead() error {
+	c.stack.mu.Lock()
+	defer c.stack.mu.Unlock()
+
+	c.readClosed = true
+	c.transfer.ClearReceiveBuffer()
+	c.windowUpdateLocked()
+	c.cond.Broadcast()
+	return nil
+}
+
+// sendFinLocked sends FIN from ESTABLISHED (active close) or CLOSE_WAIT
+// (passive close). While queued data still waits for the peer's window,
+// the FIN is deferred until all of it has been sent.
+func (c *Conn) sendFinLocked() error {
+	if len(c.tcb.Unsent) > 0 {
+		c.finPending = true
+		return nil
+	}
+	c.finPending = false
+
+	var fin *packet.TCPHeader
+	var err error
+	if c.tcb.State == socket.StateCloseWait {
+		fin, err = c.closer.CloseFromCloseWait()
+	} else {
+		fin, err = c.closer.Close()
+	}
+	if err != nil {
+		return err
+	}
+	c.stack.outputLocked(c.tcb, fin, nil)
+	return nil
+}
+
+// outputDataLocked sends queued data as far as the peer's window allows,
+// followed by a deferred FIN once nothing is left
+func (c *Conn) outputDataLocked() {
+	for {
+		header, data := c.transfer.NextSegment()
+		if header == nil {
+			break
+		}
+		c.stack.outputLocked(c.tcb, header, data)
+	}
+	if c.finPending && len(c.tcb.Unsent) == 0 {
+		c.sendFinLocked()
+	}
+	c.updatePersistLocked()
+}
+
+// updatePersistLocked starts the persist timer when queued data is blocked
+// by a zero window and nothing in flight will bring a window update, and
+// stops it once the window opens or nothing is left to send
+func (c *Conn) updatePersistLocked() {
+	idle := c.tcb.RetransmissionQueue.Size() == 0
+	switch {
+	case c.tcb.SendWindow != 0 || (idle && len(c.tcb.Unsent) == 0):
+		c.persistAt = time.Time{}
+	case c.persistAt.IsZero() && idle:
+		c.persistBackoff = c.tcb.RetransmissionTimeout
+		c.persistProbes = 0
+		c.persistAt = time.Now().Add(c.persistBackoff)
+	}
+}
+
+// probeLocked sends a window probe when the persist timer expires and
+// backs the timer off exponentially. The first probe carries one new byte;
+// later ones repeat it until the peer opens its window. The connection
+// only times out if the peer stops acknowledging the probes.
+func (c *Conn) probeLocked(now time.Time) {
+	if c.persistProbes >= c.tcb.MaxRetransmissionAttempts {
+		c.failLocked(ErrTimeout)
+		return
+	}
+
+	if entry, ok := c.tcb.RetransmissionQueue.Restart(); ok {
+		c.tcb.refreshHeader(entry.Header)
+		c.stack.outputLocked(c.tcb, entry.Header, entry.Data)
+	} else if header, data := c.transfer.Probe(); header != nil {
+		c.stack.outputLocked(c.tcb, header, data)
+	}
+	c.persistProbes++
+	c.persistBackoff = min(2*c.persistBackoff, MaxPersistTimeout)
+	c.persistAt = now.Add(c.persistBackoff)
+}
+
+// windowUpdateLocked advertises the receive window once reading has
+// opened it far enough, unless the peer has nothing more to send
+func (c *Conn) windowUpdateLocked() {
+	if c.tcb.updateRecvWindow() && c.tcb.IsSynchronized() && !c.finReceived {
+		c.sendAckLocked()
+	}
+}
+
+// waitEstablished blocks until the handshake of an active open completes
+func (c *Conn) waitEstablished(ctx context.Context) error {
+	c.stack.mu.Lock()
+	defer c.stack.mu.Unlock()
+
+	for c.tcb.State == socket.StateSynSent {
+		if err := c.waitLocked(ctx); err != nil {
+			c.failLocked(err)
+			return err
+		}
+	}
+	if !c.tcb.IsSynchronized() {
+		if c.err != nil {
+			return c.err
+		}
+		return ErrNotConnected
+	}
+	return nil
+}
+
+// waitLocked blocks until the connection changes or ctx is done
+func (c *Conn) waitLocked(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		c.stack.mu.Lock()
+		defer c.stack.mu.Unlock()
+		c.cond.Broadcast()
+	})
+	c.cond.Wait()
+	stop()
+	return ctx.Err()
+}
+
+// failLocked drops the connection and records why
+func (c *Conn) failLocked(err error) {
+	c.err = err
+	c.tcb.State = socket.StateClosed
+	c.stack.removeLocked(c)
+}
+
+// resetLocked aborts the connection by sending RST
+func (c *Conn) resetLocked(err error) {
+	rst := c.tcb.newHeader(packet.FlagRST)
+	rst.SequenceNumber = c.tcb.SendNext
+	if c.stack.transmitLocked(c.tcb.LocalAddr.IP, c.tcb.RemoteAddr.IP, rst, nil) == nil {
+		c.stack.stats.ResetsSent++
+	}
+	c.failLocked(err)
+}
+
+// acceptResetLocked reports whether a received RST aborts the connection.
+// Only a RST at exactly RCV.NXT is accepted; one elsewhere in the receive
+// window gets a challenge ACK so that blind spoofed resets fail (RFC 5961).
+func (c *Conn) acceptResetLocked(header *packet.TCPHeader) bool {
+	if header.SequenceNumber == c.tcb.RecvNext {
+		return true
+	}
+	if SeqInRange(header.SequenceNumber, c.tcb.RecvNext, c.tcb.RecvNext+c.tcb.RecvWindow) {
+		c.sendAckLocked()
+	}
+	return false
+}
+
+// sendAckLocked sends an ACK for everything received so far
+func (c *Conn) sendAckLocked() {
+	c.stack.outputLocked(c.tcb, c.tcb.newAck(), nil)
+}
+
+// handleSegment runs the TCP state machine for one incoming segment
+func (c *Conn) handleSegment(header *packet.TCPHeader, data []byte) {
+	defer c.cond.Broadcast()
+
+	switch c.tcb.State {
+	case socket.StateTimeWait:
+		c.handleTimeWait(header)
+		return
+
+	case socket.StateSynSent:
+		if header.HasFlag(packet.FlagACK) && header.AckNumber != c.tcb.SendNext {
+			// SYNを確認しないACKは古い接続のものなのでRSTを返す（RFC 793 3.9）
+			c.stack.sendResetLocked(c.tcb.LocalAddr, c.tcb.RemoteAddr, header, data)
+			return
+		}
+		if header.HasFlag(packet.FlagRST) {
+			// SYNを確認するRSTのみ受け付ける
+			if header.HasFlag(packet.FlagACK) {
+				c.failLocked(ErrConnRefused)
+			}
+			return
+		}
+		if header.HasFlag(packet.FlagSYN) && header.HasFlag(packet.FlagACK) {
+			ack, err := c.handshake.HandleSynAck(header)
+			if err != nil {
+				return
+			}
+			c.stack.outputLocked(c.tcb, ack, nil)
+		}
+		return
+
+	case socket.StateSynReceived:
+		if header.HasFlag(packet.FlagRST) {
+			if c.acceptResetLocked(header) {
+				// パッシブオープンならLISTENに戻る（リスナーは待ち受けを続ける）
+				c.tcb.State = socket.StateClosed
+				c.stack.removeLocked(c)
+			}
+			return
+		}
+		if header.HasFlag(packet.FlagSYN) || !header.HasFlag(packet.FlagACK) {
+			// SYNの再送にはSYN-ACKの再送タイマーで応える
+			return
+		}
+		if header.AckNumber != c.tcb.SendNext {
+			// SYN-ACKを確認しないACKにはRSTを返す
+			c.stack.sendResetLocked(c.tcb.LocalAddr, c.tcb.RemoteAddr, header, data)
+			return
+		}
+		if err := c.handshake.HandleAck(header); err != nil {
+			return
+		}
+		if c.listener != nil && !c.listener.establishedLocked(c) {
+			return
+		}
+	}
+
+	if c.tcb.IsSynchronized() {
+		c.handleSynchronized(header, data)
+	}
+	if c.tcb.State == socket.StateTimeWait {
+		c.startTimeWaitLocked()
+	}
+	if c.tcb.State == socket.StateClosed {
+		c.stack.removeLocked(c)
+	}
+}
+
+// handleSynchronized processes ACK, data and FIN of a segment received
+// after the handshake
+func (c *Conn) handleSynchronized(header *packet.TCPHeader, data []byte) {
+	tcb := c.tcb
+
+	if header.HasFlag(packet.FlagRST) {
+		if !c.acceptResetLocked(header) {
+			return
+		}
+		switch tcb.State {
+		case socket.StateClosing, socket.StateLastAck:
+			// 双方がFINを送った後のRSTは単に接続を閉じる
+			tcb.State = socket.StateClosed
+		default:
+			c.failLocked(ErrConnReset)
+		}
+		return
+	}
+
+	// 受け入れられないセグメントはACKを返して破棄する（RFC 793 3.9）
+	if !tcb.acceptable(header.SequenceNumber, segmentLength(header, data)) {
+		// ゼロウィンドウでもRCV.NXTから始まるセグメントのACKは処理する
+		if tcb.RecvWindow == 0 && header.SequenceNumber == tcb.RecvNext &&
+			header.HasFlag(packet.FlagACK) && !header.HasFlag(packet.FlagSYN) {
+			if !c.handleAckLocked(header) {
+				return
+			}
+		}
+		c.sendAckLocked()
+		return
+	}
+	// データを運ばない重複ACKやウィンドウ更新のTSvalも返せるよう記録する
+	tcb.recordTimestamp(header)
+
+	if header.HasFlag(packet.FlagSYN) {
+		// 最後のACKが失われてSYN-ACKが再送された場合はACKし直す
+		c.sendAckLocked()
+		return
+	}
+
+	if header.HasFlag(packet.FlagACK) && !c.handleAckLocked(header) {
+		return
+	}
+
+	// FINはデータの直後のシーケンス番号を占める
+	finSeq := header.SequenceNumber + uint32(len(data))
+
+	if len(data) > 0 {
+		_, ack, err := c.transfer.Receive(header, data)
+		if err != nil {
+			// 相手のFIN以降に届いたデータには期待するシーケンス番号を再通知する
+			c.sendAckLocked()
+			return
+		}
+		if c.readClosed {
+			// 破棄した分のウィンドウは開け直して広告する
+			c.transfer.ClearReceiveBuffer()
+			tcb.updateRecvWindow()
+			ack = tcb.newAck()
+		}
+		// FINまで受け取れた場合はFINへのACKでまとめて確認する。
+		// 順序外や重複で受け取れなかった場合のACKは重複ACKになる。
+		if !header.HasFlag(packet.FlagFIN) || tcb.RecvNext != finSeq {
+			c.stack.outputLocked(tcb, ack, nil)
+			return
+		}
+	}
+
+	if header.HasFlag(packet.FlagFIN) {
+		fin := *header
+		fin.SequenceNumber = finSeq
+		ack, err := c.closer.HandleFin(&fin)
+		if err != nil {
+			c.sendAckLocked()
+			return
+		}
+		c.finReceived = true
+		c.stack.outputLocked(tcb, ack, nil)
+	}
+}
+
+// handleAckLocked processes the ACK field of a synchronized segment and
+// sends the data the peer's window now allows. It returns false if the
+// segment acknowledges data not yet sent and must be discarded.
+func (c *Conn) handleAckLocked(header *packet.TCPHeader) bool {
+	tcb := c.tcb
+
+	// ACKが返る限りゼロウィンドウのプローブは続ける
+	c.persistProbes = 0
+	finSent := tcb.State == socket.StateFinWait1 || tcb.State == socket.StateClosing || tcb.State == socket.StateLastAck
+	if header.AckNumber == tcb.SendUnack {
+		// 新しいデータを確認しないACKもウィンドウの更新を運ぶ
+		tcb.updateSendWindow(header)
+	} else if finSent && header.AckNumber == tcb.SendNext {
+		c.closer.HandleFinAck(header)
+		tcb.updateSendWindow(header)
+	} else if err := c.transfer.ReceiveAck(header); err != nil {
+		if SeqGT(header.AckNumber, tcb.SendNext) {
+			// 未送信データへのACKにはACKを返して破棄する
+			c.sendAckLocked()
+			return false
+		}
+		// 古い重複ACKは無視してセグメントの残りを処理する
+	}
+	// ウィンドウが開いた分だけ待っていたデータを送る
+	c.outputDataLocked()
+	return true
+}
+
+// startTimeWaitLocked (re)starts the 2*MSL timer of a connection in TIME_WAIT
+func (c *Conn) startTimeWaitLocked() {
+	c.timeWaitUntil = time.Now().Add(2 * c.stack.msl)
+}
+
+// handleTimeWait processes a segment for a connection in TIME_WAIT.
+// The four-tuple is held until the 2*MSL timer expires so that delayed
+// segments of this connection cannot be taken for a new one.
+func (c *Conn) handleTimeWait(header *packet.TCPHeader) {
+	switch {
+	case header.HasFlag(packet.FlagRST):
+		// RSTでTIME_WAITを早期に終わらせない（RFC 1337）
+	case header.HasFlag(packet.FlagSYN):
+		// 再利用の条件を満たさないSYNは黙って捨てる（RFC 6191）
+	case header.HasFlag(packet.FlagFIN):
+		// 最後のACKが失われてFINが再送された場合はACKし直し、タイマーを再始動する
+		c.sendAckLocked()
+		c.startTimeWaitLocked()
+	}
+}
+
+// reusableLocked reports whether header is a SYN that may take over the
+// four-tuple of this TIME_WAIT connection (RFC 6191). With timestamps the
+// SYN must carry a newer timestamp, otherwise a higher sequence number.
+func (c *Conn) reusableLocked(header *packet.TCPHeader) bool {
+	if c.tcb.State != socket.StateTimeWait || !header.HasFlag(packet.FlagSYN) ||
+		header.HasFlag(packet.FlagACK) || header.HasFlag(packet.FlagRST) {
+		return false
+	}
+
+	ts, ok := header.FindOption(packet.OptionKindTimestamps).(packet.TimestampsOption)
+	if ok {
+		// 前の接続がタイムスタンプを使っていなければ比較できないので受け入れる
+		return !c.tcb.TimestampsOK || int32(ts.Value-c.tcb.TSRecent) > 0
+	}
+	return SeqGT(header.SequenceNumber, c.tcb.RecvNext)
+}
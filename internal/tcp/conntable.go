@@ -0,0 +1,156 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// Errors returned by ConnTable
+var (
+	ErrConnExists = errors.New("tcp: connection already exists")
+	ErrAddrInUse  = errors.New("tcp: address already in use")
+)
+
+// FourTuple identifies a connection by its local and remote endpoints.
+// IPv4-mapped IPv6 addresses are unmapped so that a connection has the same
+// key whether it is seen through IPv4 or through a dual-stack socket.
+type FourTuple struct {
+	Local  netip.AddrPort
+	Remote netip.AddrPort
+}
+
+// NewFourTuple creates the lookup key for a pair of TCP addresses
+func NewFourTuple(local, remote *net.TCPAddr) FourTuple {
+	return FourTuple{Local: AddrPort(local), Remote: AddrPort(remote)}
+}
+
+// String returns a string representation of the four-tuple
+func (t FourTuple) String() string {
+	return fmt.Sprintf("%s -> %s", t.Local, t.Remote)
+}
+
+// AddrPort converts a TCP address into a normalized netip.AddrPort.
+// An address without IP (e.g. ":8080") becomes the IPv6 unspecified
+// address, which accepts both IPv4 and IPv6 (dual-stack).
+func AddrPort(addr *net.TCPAddr) netip.AddrPort {
+	if addr == nil {
+		return netip.AddrPort{}
+	}
+	ip, ok := netip.AddrFromSlice(addr.IP)
+	if !ok {
+		ip = netip.IPv6Unspecified()
+	}
+	return netip.AddrPortFrom(ip.Unmap(), uint16(addr.Port))
+}
+
+// FourTuple returns the lookup key of the TCB
+func (tcb *TCB) FourTuple() FourTuple {
+	return NewFourTuple(tcb.LocalAddr, tcb.RemoteAddr)
+}
+
+// ConnTable looks up TCBs for incoming segments, for both IPv4 and IPv6
+type ConnTable struct {
+	mu        sync.RWMutex
+	conns     map[FourTuple]*TCB
+	listeners map[netip.AddrPort]*TCB
+}
+
+// NewConnTable creates an empty connection table
+func NewConnTable() *ConnTable {
+	return &ConnTable{
+		conns:     make(map[FourTuple]*TCB),
+		listeners: make(map[netip.AddrPort]*TCB),
+	}
+}
+
+// Add registers a connected TCB under its four-tuple
+func (t *ConnTable) Add(tcb *TCB) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := tcb.FourTuple()
+	if _, ok := t.conns[key]; ok {
+		return fmt.Errorf("%w: %s", ErrConnExists, key)
+	}
+	t.conns[key] = tcb
+	return nil
+}
+
+// AddListener registers a listening TCB under its local address
+func (t *ConnTable) AddListener(tcb *TCB) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := AddrPort(tcb.LocalAddr)
+	if _, ok := t.listeners[key]; ok {
+		return fmt.Errorf("%w: %s", ErrAddrInUse, key)
+	}
+	t.listeners[key] = tcb
+	return nil
+}
+
+// Remove unregisters a connected TCB
+func (t *ConnTable) Remove(tcb *TCB) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := tcb.FourTuple()
+	if t.conns[key] == tcb {
+		delete(t.conns, key)
+	}
+}
+
+// RemoveListener unregisters a listening TCB
+func (t *ConnTable) RemoveListener(tcb *TCB) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := AddrPort(tcb.LocalAddr)
+	if t.listeners[key] == tcb {
+		delete(t.listeners, key)
+	}
+}
+
+// Lookup returns the connection for a four-tuple, or nil
+func (t *ConnTable) Lookup(key FourTuple) *TCB {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.conns[normalize(key)]
+}
+
+// LookupListener returns the listener accepting connections to local, or nil.
+// A listener bound to the exact address wins over a wildcard one; 0.0.0.0
+// only matches IPv4 while :: matches both address families.
+func (t *ConnTable) LookupListener(local netip.AddrPort) *TCB {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	local = netip.AddrPortFrom(local.Addr().Unmap(), local.Port())
+	if tcb, ok := t.listeners[local]; ok {
+		return tcb
+	}
+	if local.Addr().Is4() {
+		if tcb, ok := t.listeners[netip.AddrPortFrom(netip.IPv4Unspecified(), local.Port())]; ok {
+			return tcb
+		}
+	}
+	return t.listeners[netip.AddrPortFrom(netip.IPv6Unspecified(), local.Port())]
+}
+
+// Len returns the number of connected TCBs
+func (t *ConnTable) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.conns)
+}
+
+// normalize unmaps IPv4-mapped addresses in a four-tuple
+func normalize(key FourTuple) FourTuple {
+	return FourTuple{
+		Local:  netip.AddrPortFrom(key.Local.Addr().Unmap(), key.Local.Port()),
+		Remote: netip.AddrPortFrom(key.Remote.Addr().Unmap(), key.Remote.Port()),
+	}
+}
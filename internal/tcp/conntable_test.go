@@ -0,0 +1,115 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestFourTupleNormalization(t *testing.T) {
+	v4 := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 80}
+	mapped := &net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 80}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.2").To4(), Port: 5000}
+
+	if NewFourTuple(v4, remote) != NewFourTuple(mapped, remote) {
+		t.Errorf("IPv4 and IPv4-mapped addresses should give the same key: %s vs %s",
+			NewFourTuple(v4, remote), NewFourTuple(mapped, remote))
+	}
+
+	// ":8080" means every address of both families
+	wildcard, _ := net.ResolveTCPAddr("tcp", ":8080")
+	if got := AddrPort(wildcard); got != netip.MustParseAddrPort("[::]:8080") {
+		t.Errorf("Expected [::]:8080, got %s", got)
+	}
+}
+
+func TestConnTableLookup(t *testing.T) {
+	table := NewConnTable()
+
+	v4Local := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 80}
+	v4Remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 5000}
+	v6Local := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 80}
+	v6Remote := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 5000}
+
+	v4Conn := NewTCB(v4Local, v4Remote)
+	v6Conn := NewTCB(v6Local, v6Remote)
+	if err := table.Add(v4Conn); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := table.Add(v6Conn); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := table.Add(NewTCB(v4Local, v4Remote)); !errors.Is(err, ErrConnExists) {
+		t.Errorf("Expected ErrConnExists, got %v", err)
+	}
+
+	if got := table.Lookup(NewFourTuple(v4Local, v4Remote)); got != v4Conn {
+		t.Errorf("Expected IPv4 connection, got %v", got)
+	}
+	if got := table.Lookup(NewFourTuple(v6Local, v6Remote)); got != v6Conn {
+		t.Errorf("Expected IPv6 connection, got %v", got)
+	}
+
+	// A key built from IPv4-mapped addresses finds the same connection
+	mappedKey := FourTuple{
+		Local:  netip.MustParseAddrPort("[::ffff:192.0.2.1]:80"),
+		Remote: netip.MustParseAddrPort("[::ffff:192.0.2.2]:5000"),
+	}
+	if got := table.Lookup(mappedKey); got != v4Conn {
+		t.Errorf("Expected IPv4 connection for mapped key, got %v", got)
+	}
+
+	table.Remove(v4Conn)
+	if table.Lookup(NewFourTuple(v4Local, v4Remote)) != nil || table.Len() != 1 {
+		t.Errorf("Expected IPv4 connection to be removed, %d left", table.Len())
+	}
+}
+
+func TestConnTableDualStackListener(t *testing.T) {
+	table := NewConnTable()
+
+	dualStack, _ := net.ResolveTCPAddr("tcp", ":8080")
+	dual := NewTCB(dualStack, nil)
+	if err := table.AddListener(dual); err != nil {
+		t.Fatalf("AddListener failed: %v", err)
+	}
+	if err := table.AddListener(NewTCB(dualStack, nil)); !errors.Is(err, ErrAddrInUse) {
+		t.Errorf("Expected ErrAddrInUse, got %v", err)
+	}
+
+	for _, addr := range []string{"192.0.2.1:8080", "[2001:db8::1]:8080", "[::ffff:192.0.2.1]:8080"} {
+		if got := table.LookupListener(netip.MustParseAddrPort(addr)); got != dual {
+			t.Errorf("%s: expected dual-stack listener, got %v", addr, got)
+		}
+	}
+	if got := table.LookupListener(netip.MustParseAddrPort("192.0.2.1:9090")); got != nil {
+		t.Errorf("Expected no listener on another port, got %v", got)
+	}
+
+	// An IPv4 wildcard listener only takes IPv4 and wins over ::
+	v4Any := NewTCB(&net.TCPAddr{IP: net.IPv4zero, Port: 8080}, nil)
+	if err := table.AddListener(v4Any); err != nil {
+		t.Fatalf("AddListener failed: %v", err)
+	}
+	if got := table.LookupListener(netip.MustParseAddrPort("192.0.2.1:8080")); got != v4Any {
+		t.Errorf("Expected IPv4 wildcard listener, got %v", got)
+	}
+	if got := table.LookupListener(netip.MustParseAddrPort("[2001:db8::1]:8080")); got != dual {
+		t.Errorf("Expected dual-stack listener for IPv6, got %v", got)
+	}
+
+	// The most specific binding wins
+	exact := NewTCB(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 8080}, nil)
+	if err := table.AddListener(exact); err != nil {
+		t.Fatalf("AddListener failed: %v", err)
+	}
+	if got := table.LookupListener(netip.MustParseAddrPort("192.0.2.1:8080")); got != exact {
+		t.Errorf("Expected exact listener, got %v", got)
+	}
+
+	table.RemoveListener(exact)
+	if got := table.LookupListener(netip.MustParseAddrPort("192.0.2.1:8080")); got != v4Any {
+		t.Errorf("Expected IPv4 wildcard listener after removal, got %v", got)
+	}
+}
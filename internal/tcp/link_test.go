@@ -0,0 +1,160 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sasakihasuto/tinytcp/internal/ip"
+	"github.com/sasakihasuto/tinytcp/internal/link"
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+	"github.com/sasakihasuto/tinytcp/internal/socket"
+)
+
+// segment is a decoded TCP segment received from a link
+type segment struct {
+	header *packet.TCPHeader
+	data   []byte
+}
+
+// attach decodes and checksums IPv4/TCP packets arriving on ep into a channel
+func attach(t *testing.T, ep link.LinkEndpoint) <-chan segment {
+	t.Helper()
+
+	segments := make(chan segment, 16)
+	demux := ip.NewDemux()
+	demux.Register(packet.ProtocolTCP, func(src, dst net.IP, payload []byte) {
+		h, data, err := packet.Unmarshal(payload)
+		if err != nil {
+			t.Errorf("Failed to decode segment: %v", err)
+			return
+		}
+		if err := packet.VerifySegment(src, dst, payload); err != nil {
+			t.Errorf("Received corrupted segment: %v", err)
+			return
+		}
+		segments <- segment{header: h, data: append([]byte(nil), data...)}
+	})
+	ep.SetDeliver(func(pkt []byte) {
+		if err := demux.Deliver(pkt); err != nil {
+			t.Errorf("Failed to deliver packet: %v", err)
+		}
+	})
+	return segments
+}
+
+// transmit wraps a segment from tcb into an IPv4 packet and writes it to ep
+func transmit(t *testing.T, ep link.LinkEndpoint, tcb *TCB, header *packet.TCPHeader, data []byte) {
+	t.Helper()
+
+	if err := header.SetChecksum(tcb.LocalAddr.IP, tcb.RemoteAddr.IP, data); err != nil {
+		t.Fatalf("Failed to set checksum: %v", err)
+	}
+	b, err := header.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal segment: %v", err)
+	}
+	segment := append(b, data...)
+
+	ipHeader := ip.NewIPv4Header(tcb.LocalAddr.IP, tcb.RemoteAddr.IP, packet.ProtocolTCP, len(segment))
+	ipBytes, err := ipHeader.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal IP header: %v", err)
+	}
+	if err := ep.WritePacket(append(ipBytes, segment...)); err != nil {
+		t.Fatalf("Failed to write packet: %v", err)
+	}
+}
+
+func receive(t *testing.T, segments <-chan segment) segment {
+	t.Helper()
+
+	select {
+	case s := <-segments:
+		return s
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for segment")
+		return segment{}
+	}
+}
+
+func TestHandshakeAndTransferOverPipe(t *testing.T) {
+	clientAddr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 40000}
+	serverAddr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 80}
+
+	clientLink, serverLink := link.NewPipe(1500)
+	defer clientLink.Close()
+	defer serverLink.Close()
+	toClient := attach(t, clientLink)
+	toServer := attach(t, serverLink)
+
+	clientTCB := NewTCB(clientAddr, serverAddr)
+	serverTCB := NewTCB(serverAddr, clientAddr)
+	serverTCB.State = socket.StateListen
+	clientTCB.VerifyChecksum = true
+	serverTCB.VerifyChecksum = true
+
+	clientHandshake := NewThreeWayHandshake(clientTCB)
+	serverHandshake := NewThreeWayHandshake(serverTCB)
+
+	// 3ウェイハンドシェイク
+	syn, err := clientHandshake.StartClient()
+	if err != nil {
+		t.Fatalf("Failed to start client handshake: %v", err)
+	}
+	transmit(t, clientLink, clientTCB, syn, nil)
+
+	s := receive(t, toServer)
+	synAck, err := serverHandshake.HandleSyn(s.header)
+	if err != nil {
+		t.Fatalf("Failed to handle SYN: %v", err)
+	}
+	transmit(t, serverLink, serverTCB, synAck, nil)
+
+	s = receive(t, toClient)
+	ack, err := clientHandshake.HandleSynAck(s.header)
+	if err != nil {
+		t.Fatalf("Failed to handle SYN-ACK: %v", err)
+	}
+	transmit(t, clientLink, clientTCB, ack, nil)
+
+	s = receive(t, toServer)
+	if err := serverHandshake.HandleAck(s.header); err != nil {
+		t.Fatalf("Failed to handle final ACK: %v", err)
+	}
+	if clientTCB.State != socket.StateEstablished || serverTCB.State != socket.StateEstablished {
+		t.Fatalf("Expected both ESTABLISHED, got %s and %s", clientTCB.State, serverTCB.State)
+	}
+
+	// データ転送
+	clientDT := NewDataTransfer(clientTCB)
+	serverDT := NewDataTransfer(serverTCB)
+
+	message := []byte("Hello over the pipe!")
+	dataHeader, err := clientDT.Send(message)
+	if err != nil {
+		t.Fatalf("Failed to send data: %v", err)
+	}
+	transmit(t, clientLink, clientTCB, dataHeader, message)
+
+	s = receive(t, toServer)
+	received, dataAck, err := serverDT.Receive(s.header, s.data)
+	if err != nil {
+		t.Fatalf("Failed to receive data: %v", err)
+	}
+	if string(received) != string(message) {
+		t.Errorf("Expected %q, got %q", message, received)
+	}
+	transmit(t, serverLink, serverTCB, dataAck, nil)
+
+	s = receive(t, toClient)
+	if err := clientDT.ReceiveAck(s.header); err != nil {
+		t.Fatalf("Failed to process ACK: %v", err)
+	}
+	if clientDT.GetRetransmissionQueueSize() != 0 {
+		t.Errorf("Expected empty retransmission queue, got %d", clientDT.GetRetransmissionQueueSize())
+	}
+	if clientTCB.ChecksumErrors != 0 || serverTCB.ChecksumErrors != 0 {
+		t.Errorf("Unexpected checksum errors: %d and %d", clientTCB.ChecksumErrors, serverTCB.ChecksumErrors)
+	}
+}
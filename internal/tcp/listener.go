@@ -0,0 +1,161 @@
+package tcp
+
+import (
+	"context"
+	"net"
+
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+	"github.com/sasakihasuto/tinytcp/internal/socket"
+)
+
+// DefaultBacklog is the listen backlog used when none is given
+const DefaultBacklog = 128
+
+// Listener accepts connections to a local address.
+//
+// Incoming SYNs create child connections in SYN_RECEIVED state that are kept
+// in the SYN queue; once the final ACK of the handshake arrives they move to
+// the accept queue until Accept returns them. Both queues hold at most
+// backlog connections: further SYNs are dropped (the peer will retransmit)
+// and handshakes completing into a full accept queue are reset.
+type Listener struct {
+	stack   *Stack
+	tcb     *TCB
+	backlog int
+
+	synQueue    map[*Conn]struct{} // SYN_RECEIVED状態の子接続
+	acceptQueue chan *Conn         // Accept待ちの確立済み子接続
+
+	done   chan struct{}
+	closed bool
+}
+
+// newListener creates a listener for a TCB in LISTEN state
+func newListener(s *Stack, tcb *TCB, backlog int) *Listener {
+	return &Listener{
+		stack:       s,
+		tcb:         tcb,
+		backlog:     backlog,
+		synQueue:    make(map[*Conn]struct{}),
+		acceptQueue: make(chan *Conn, backlog),
+		done:        make(chan struct{}),
+	}
+}
+
+// Accept waits for the next established connection
+func (l *Listener) Accept(ctx context.Context) (socket.Conn, error) {
+	select {
+	case <-l.done:
+		return nil, ErrListenerClosed
+	default:
+	}
+
+	select {
+	case c := <-l.acceptQueue:
+		return c, nil
+	case <-l.done:
+		return nil, ErrListenerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Addr returns the local address the listener is bound to
+func (l *Listener) Addr() *net.TCPAddr {
+	return l.tcb.LocalAddr
+}
+
+// Backlog returns the maximum length of the SYN and accept queues
+func (l *Listener) Backlog() int {
+	return l.backlog
+}
+
+// Close stops accepting connections and resets the connections that were
+// not accepted yet
+func (l *Listener) Close() error {
+	l.stack.mu.Lock()
+	defer l.stack.mu.Unlock()
+	l.closeLocked()
+	return nil
+}
+
+func (l *Listener) closeLocked() {
+	if l.closed {
+		return
+	}
+	l.closed = true
+	l.tcb.State = socket.StateClosed
+	l.stack.table.RemoveListener(l.tcb)
+	delete(l.stack.listeners, l.tcb)
+	close(l.done)
+
+	for c := range l.synQueue {
+		c.resetLocked(ErrListenerClosed)
+	}
+	for {
+		select {
+		case c := <-l.acceptQueue:
+			c.resetLocked(ErrListenerClosed)
+		default:
+			return
+		}
+	}
+}
+
+// handleSyn creates a connection in SYN_RECEIVED state for a new SYN.
+// prev is the TIME_WAIT connection whose four-tuple the SYN reuses, if any;
+// it is closed only once the SYN is accepted.
+func (l *Listener) handleSyn(local, remote *net.TCPAddr, syn *packet.TCPHeader, prev *Conn) {
+	s := l.stack
+
+	if len(l.synQueue) >= l.backlog {
+		s.stats.ListenDrops++
+		return
+	}
+
+	tcb := NewTCB(local, remote)
+	tcb.State = socket.StateListen
+	tcb.MSS = l.tcb.MSS
+	if prev != nil {
+		sendNext := prev.tcb.SendNext
+		tcb.prevSendNext = &sendNext
+	}
+
+	c := s.newConnLocked(tcb)
+	c.listener = l
+
+	synAck, err := c.handshake.HandleSyn(syn)
+	if err != nil {
+		return
+	}
+	if prev != nil {
+		// TIME_WAITの4タプルを新しい接続に明け渡す（RFC 6191）
+		prev.tcb.State = socket.StateClosed
+		s.removeLocked(prev)
+	}
+	if err := s.table.Add(tcb); err != nil {
+		return
+	}
+	s.conns[tcb] = c
+	l.synQueue[c] = struct{}{}
+	s.outputLocked(tcb, synAck, nil)
+}
+
+// establishedLocked moves a child whose handshake completed from the SYN
+// queue to the accept queue. It returns false if the child was reset instead.
+func (l *Listener) establishedLocked(c *Conn) bool {
+	delete(l.synQueue, c)
+	if l.closed {
+		c.resetLocked(ErrListenerClosed)
+		return false
+	}
+
+	select {
+	case l.acceptQueue <- c:
+		return true
+	default:
+		l.stack.stats.ListenDrops++
+		c.resetLocked(ErrConnAborted)
+		return false
+	}
+}
@@ -0,0 +1,120 @@
+package tcp
+
+import (
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+)
+
+// ReassemblyQueue holds data received out of order, beyond RCV.NXT, until
+// the gap in front of it is filled. The data is kept as a sorted list of
+// disjoint blocks; overlapping and adjacent segments are coalesced so every
+// byte is stored once.
+//
+// Receive only inserts data that lies inside the receive window, so the
+// queue never holds more than the advertised window.
+type ReassemblyQueue struct {
+	blocks []reassemblyBlock
+	recent uint32 // 最後に挿入したセグメントの先頭（SACKの最初のブロック用）
+}
+
+// reassemblyBlock is a contiguous run of out-of-order data
+type reassemblyBlock struct {
+	seq  uint32
+	data []byte
+}
+
+func (b reassemblyBlock) end() uint32 {
+	return b.seq + uint32(len(b.data))
+}
+
+// NewReassemblyQueue creates an empty reassembly queue
+func NewReassemblyQueue() *ReassemblyQueue {
+	return &ReassemblyQueue{}
+}
+
+// Insert stores a copy of data starting at seq, merging it with the blocks
+// it overlaps or touches
+func (q *ReassemblyQueue) Insert(seq uint32, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	q.recent = seq
+
+	merged := reassemblyBlock{seq: seq, data: append([]byte(nil), data...)}
+	blocks := make([]reassemblyBlock, 0, len(q.blocks)+1)
+	inserted := false
+	for _, b := range q.blocks {
+		switch {
+		case SeqLT(b.end(), merged.seq):
+			blocks = append(blocks, b)
+		case SeqGT(b.seq, merged.end()):
+			if !inserted {
+				blocks = append(blocks, merged)
+				inserted = true
+			}
+			blocks = append(blocks, b)
+		default:
+			// 重なるか隣接するブロックは1つにまとめる
+			if SeqGT(b.end(), merged.end()) {
+				merged.data = append(merged.data, b.data[merged.end()-b.seq:]...)
+			}
+			if SeqLT(b.seq, merged.seq) {
+				merged.data = append(append([]byte(nil), b.data[:merged.seq-b.seq]...), merged.data...)
+				merged.seq = b.seq
+			}
+		}
+	}
+	if !inserted {
+		blocks = append(blocks, merged)
+	}
+	q.blocks = blocks
+}
+
+// Pop removes the data that has become contiguous with next (RCV.NXT) and
+// returns the bytes from next onwards. Blocks entirely before next are
+// discarded.
+func (q *ReassemblyQueue) Pop(next uint32) []byte {
+	var out []byte
+	for len(q.blocks) > 0 && SeqLEQ(q.blocks[0].seq, next) {
+		b := q.blocks[0]
+		q.blocks = q.blocks[1:]
+		if SeqGT(b.end(), next) {
+			out = append(out, b.data[next-b.seq:]...)
+			next = b.end()
+		}
+	}
+	return out
+}
+
+// Len returns the number of bytes held
+func (q *ReassemblyQueue) Len() int {
+	n := 0
+	for _, b := range q.blocks {
+		n += len(b.data)
+	}
+	return n
+}
+
+// Clear discards all held data
+func (q *ReassemblyQueue) Clear() {
+	q.blocks = nil
+}
+
+// SACKBlocks returns the held blocks for SACK generation (RFC 2018): the
+// block containing the most recently received segment comes first, the
+// others follow in sequence order
+func (q *ReassemblyQueue) SACKBlocks() []packet.SACKBlock {
+	if len(q.blocks) == 0 {
+		return nil
+	}
+
+	blocks := make([]packet.SACKBlock, 0, len(q.blocks))
+	for _, b := range q.blocks {
+		block := packet.SACKBlock{Left: b.seq, Right: b.end()}
+		if SeqInRange(q.recent, b.seq, b.end()) {
+			blocks = append([]packet.SACKBlock{block}, blocks...)
+		} else {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
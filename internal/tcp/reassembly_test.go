@@ -0,0 +1,89 @@
+package tcp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+)
+
+func TestReassemblyQueue_Coalesce(t *testing.T) {
+	q := NewReassemblyQueue()
+
+	q.Insert(1020, []byte("0123456789"))
+	q.Insert(1040, []byte("abcde"))
+	if q.Len() != 15 {
+		t.Errorf("Expected 15 bytes, got %d", q.Len())
+	}
+
+	// 重なるセグメントは1つのブロックにまとめられ、重複バイトは1回だけ保持される
+	q.Insert(1025, []byte("5678901234"))
+	expected := []packet.SACKBlock{{Left: 1020, Right: 1035}, {Left: 1040, Right: 1045}}
+	if blocks := q.SACKBlocks(); !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("Expected blocks %v, got %v", expected, blocks)
+	}
+
+	// 隣接するセグメントで隙間が埋まると2つのブロックが結合される
+	q.Insert(1035, []byte("XXXXX"))
+	expected = []packet.SACKBlock{{Left: 1020, Right: 1045}}
+	if blocks := q.SACKBlocks(); !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("Expected blocks %v, got %v", expected, blocks)
+	}
+	if q.Len() != 25 {
+		t.Errorf("Expected 25 bytes, got %d", q.Len())
+	}
+}
+
+func TestReassemblyQueue_Pop(t *testing.T) {
+	q := NewReassemblyQueue()
+	q.Insert(1010, []byte("bbbbbbbbbb"))
+	q.Insert(1030, []byte("dddddddddd"))
+
+	// 先頭のブロックに届いていなければ何も返さない
+	if data := q.Pop(1000); data != nil {
+		t.Errorf("Expected no data, got %q", data)
+	}
+
+	// RCV.NXTがブロックの途中にあれば、それ以降だけを返す
+	if data := q.Pop(1015); string(data) != "bbbbb" {
+		t.Errorf("Expected %q, got %q", "bbbbb", data)
+	}
+
+	// RCV.NXTより前のブロックは捨てられる
+	if data := q.Pop(1040); data != nil {
+		t.Errorf("Expected no data, got %q", data)
+	}
+	if q.Len() != 0 {
+		t.Errorf("Expected empty queue, got %d bytes", q.Len())
+	}
+}
+
+func TestReassemblyQueue_SACKBlocksOrder(t *testing.T) {
+	q := NewReassemblyQueue()
+	q.Insert(1000, []byte("aaaa"))
+	q.Insert(1020, []byte("cccc"))
+	q.Insert(1010, []byte("bbbb"))
+
+	// 最後に受信したセグメントを含むブロックが最初に来る（RFC 2018）
+	expected := []packet.SACKBlock{{Left: 1010, Right: 1014}, {Left: 1000, Right: 1004}, {Left: 1020, Right: 1024}}
+	if blocks := q.SACKBlocks(); !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("Expected blocks %v, got %v", expected, blocks)
+	}
+
+	q.Clear()
+	if blocks := q.SACKBlocks(); blocks != nil {
+		t.Errorf("Expected no blocks after Clear, got %v", blocks)
+	}
+}
+
+func TestReassemblyQueue_Wraparound(t *testing.T) {
+	q := NewReassemblyQueue()
+	start := uint32(0xFFFFFFF0)
+
+	// シーケンス番号の折り返しをまたいで結合・取り出しができる
+	q.Insert(start+24, []byte("cccccccc"))
+	q.Insert(start+8, []byte("bbbbbbbbbbbbbbbb"))
+	if data := q.Pop(start + 8); string(data) != "bbbbbbbbbbbbbbbbcccccccc" {
+		t.Errorf("Expected data across wraparound, got %q", data)
+	}
+}
@@ -55,6 +55,8 @@ func TestDataTransferWithRetransmission(t *testing.T) {
 	tcb := NewTCB(localAddr, remoteAddr)
 	tcb.State = socket.StateEstablished
 	tcb.SendNext = 1000
+	tcb.SendUnack = 1000
+	tcb.SendWindow = 65535
 	tcb.RecvNext = 2000
 	tcb.RetransmissionTimeout = 10 * time.Millisecond
 
@@ -247,3 +249,153 @@ func TestRetransmissionTimeout(t *testing.T) {
 		t.Errorf("Expected max attempts %d, got %d", maxAttempts, tcb.MaxRetransmissionAttempts)
 	}
 }
+
+func TestRetransmissionQueueRestart(t *testing.T) {
+	rq := NewRetransmissionQueue()
+	if _, ok := rq.Restart(); ok {
+		t.Error("Expected no entry in an empty queue")
+	}
+
+	header := packet.NewTCPHeader(8080, 9090)
+	header.SequenceNumber = 1000
+	rq.Add(header, []byte("x"))
+	time.Sleep(10 * time.Millisecond)
+
+	// 再送回数は数えずにタイマーだけ再始動する
+	entry, ok := rq.Restart()
+	if !ok || entry.Header.SequenceNumber != 1000 || entry.Attempts != 1 {
+		t.Errorf("Expected first entry with 1 attempt, got %+v", entry)
+	}
+	if entries := rq.GetTimeoutEntries(5*time.Millisecond, 3); len(entries) != 0 {
+		t.Errorf("Expected restarted entry not to time out yet, got %d entries", len(entries))
+	}
+}
+
+func TestDataTransferProbe(t *testing.T) {
+	localAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+	remoteAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9090}
+
+	tcb := NewTCB(localAddr, remoteAddr)
+	tcb.State = socket.StateEstablished
+	tcb.SendNext = 1000
+	tcb.SendUnack = 1000
+	tcb.SendWindow = 0
+	dt := NewDataTransfer(tcb)
+
+	if header, _ := dt.Probe(); header != nil {
+		t.Errorf("Expected no probe without queued data, got %s", header)
+	}
+
+	dt.Queue([]byte("abc"))
+	if header, _ := dt.NextSegment(); header != nil {
+		t.Fatalf("Expected no segment with a zero window, got %s", header)
+	}
+
+	// プローブはゼロウィンドウを超えて1バイトだけ送り、再送キューに入る
+	header, data := dt.Probe()
+	if header == nil || header.SequenceNumber != 1000 || string(data) != "a" {
+		t.Fatalf("Expected 1 byte probe at 1000, got %v and %q", header, data)
+	}
+	if tcb.SendNext != 1001 || string(tcb.Unsent) != "bc" || dt.GetRetransmissionQueueSize() != 1 {
+		t.Errorf("Expected SendNext 1001, %q unsent and 1 queued segment, got %d, %q and %d",
+			"bc", tcb.SendNext, tcb.Unsent, dt.GetRetransmissionQueueSize())
+	}
+}
+
+func TestUpdateRTO(t *testing.T) {
+	tcb := NewTCB(nil, nil)
+
+	// 最初の計測ではSRTT=R, RTTVAR=R/2
+	tcb.updateRTO(100 * time.Millisecond)
+	if tcb.SRTT != 100*time.Millisecond || tcb.RTTVAR != 50*time.Millisecond {
+		t.Errorf("Expected SRTT 100ms and RTTVAR 50ms, got %v and %v", tcb.SRTT, tcb.RTTVAR)
+	}
+	if tcb.RetransmissionTimeout != 300*time.Millisecond {
+		t.Errorf("Expected RTO 300ms, got %v", tcb.RetransmissionTimeout)
+	}
+
+	tcb.updateRTO(200 * time.Millisecond)
+	if tcb.SRTT != 112500*time.Microsecond || tcb.RTTVAR != 62500*time.Microsecond {
+		t.Errorf("Expected SRTT 112.5ms and RTTVAR 62.5ms, got %v and %v", tcb.SRTT, tcb.RTTVAR)
+	}
+	if tcb.RetransmissionTimeout != 362500*time.Microsecond {
+		t.Errorf("Expected RTO 362.5ms, got %v", tcb.RetransmissionTimeout)
+	}
+
+	// 計算結果は下限と上限に収める
+	tcb = NewTCB(nil, nil)
+	tcb.updateRTO(time.Millisecond)
+	if tcb.RetransmissionTimeout != DefaultMinRetransmissionTimeout {
+		t.Errorf("Expected RTO %v, got %v", DefaultMinRetransmissionTimeout, tcb.RetransmissionTimeout)
+	}
+	tcb = NewTCB(nil, nil)
+	tcb.MaxRetransmissionTimeout = 2 * time.Second
+	tcb.updateRTO(time.Second)
+	if tcb.RetransmissionTimeout != 2*time.Second {
+		t.Errorf("Expected RTO 2s, got %v", tcb.RetransmissionTimeout)
+	}
+}
+
+func TestBackoffRTO(t *testing.T) {
+	tcb := NewTCB(nil, nil)
+	tcb.RetransmissionTimeout = 300 * time.Millisecond
+	tcb.MaxRetransmissionTimeout = time.Second
+
+	for _, expected := range []time.Duration{600 * time.Millisecond, time.Second, time.Second} {
+		tcb.backoffRTO()
+		if tcb.RetransmissionTimeout != expected {
+			t.Errorf("Expected RTO %v, got %v", expected, tcb.RetransmissionTimeout)
+		}
+	}
+}
+
+func TestRTTSampleKarn(t *testing.T) {
+	localAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+	remoteAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9090}
+
+	tcb := NewTCB(localAddr, remoteAddr)
+	tcb.State = socket.StateEstablished
+	tcb.SendNext = 1000
+	tcb.SendUnack = 1000
+	tcb.SendWindow = 65535
+	tcb.RecvNext = 2000
+	tcb.RetransmissionTimeout = time.Millisecond
+	dt := NewDataTransfer(tcb)
+
+	ack := func(n uint32) {
+		header := packet.NewTCPHeader(9090, 8080)
+		header.SequenceNumber = 2000
+		header.AckNumber = n
+		header.SetFlag(packet.FlagACK)
+		if err := dt.ReceiveAck(header); err != nil {
+			t.Fatalf("Failed to receive ACK: %v", err)
+		}
+	}
+
+	// 再送したセグメントのACKではRTTを計測しない
+	if _, err := dt.Send([]byte("abc")); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if entries, _ := dt.CheckRetransmissions(); len(entries) != 1 {
+		t.Fatalf("Expected 1 retransmission, got %d", len(entries))
+	}
+	if tcb.RetransmissionTimeout != 2*time.Millisecond {
+		t.Errorf("Expected RTO backed off to 2ms, got %v", tcb.RetransmissionTimeout)
+	}
+	ack(1003)
+	if tcb.SRTT != 0 {
+		t.Errorf("Expected no RTT sample from a retransmitted segment, got SRTT %v", tcb.SRTT)
+	}
+
+	if _, err := dt.Send([]byte("def")); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	ack(1006)
+	if tcb.SRTT == 0 {
+		t.Error("Expected an RTT sample from a segment sent once")
+	}
+	if tcb.RetransmissionTimeout < DefaultMinRetransmissionTimeout {
+		t.Errorf("Expected RTO of at least %v, got %v", DefaultMinRetransmissionTimeout, tcb.RetransmissionTimeout)
+	}
+}
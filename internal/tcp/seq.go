@@ -0,0 +1,31 @@
+package tcp
+
+// Sequence numbers are 32-bit and wrap around, so they cannot be compared
+// with plain < and >. The functions below use serial number arithmetic
+// (RFC 1982): a is before b when b is less than 2^31 ahead of a.
+
+// SeqLT reports whether sequence number a is before b
+func SeqLT(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// SeqLEQ reports whether sequence number a is before or equal to b
+func SeqLEQ(a, b uint32) bool {
+	return int32(a-b) <= 0
+}
+
+// SeqGT reports whether sequence number a is after b
+func SeqGT(a, b uint32) bool {
+	return int32(a-b) > 0
+}
+
+// SeqGEQ reports whether sequence number a is after or equal to b
+func SeqGEQ(a, b uint32) bool {
+	return int32(a-b) >= 0
+}
+
+// SeqInRange reports whether seq lies in the half-open range [start, end),
+// e.g. a receive window starting at RCV.NXT
+func SeqInRange(seq, start, end uint32) bool {
+	return seq-start < end-start
+}
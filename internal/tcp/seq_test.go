@@ -0,0 +1,169 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/sasakihasuto/tinytcp/internal/socket"
+)
+
+func TestSeqComparison(t *testing.T) {
+	tests := []struct {
+		a, b             uint32
+		lt, leq, gt, geq bool
+	}{
+		{1, 2, true, true, false, false},
+		{2, 1, false, false, true, true},
+		{5, 5, false, true, false, true},
+		// 2^32を跨いでも順序が保たれる
+		{0xFFFFFFF0, 0x10, true, true, false, false},
+		{0x10, 0xFFFFFFF0, false, false, true, true},
+		{0xFFFFFFFF, 0, true, true, false, false},
+	}
+
+	for _, test := range tests {
+		if got := SeqLT(test.a, test.b); got != test.lt {
+			t.Errorf("SeqLT(%#x, %#x) = %v, expected %v", test.a, test.b, got, test.lt)
+		}
+		if got := SeqLEQ(test.a, test.b); got != test.leq {
+			t.Errorf("SeqLEQ(%#x, %#x) = %v, expected %v", test.a, test.b, got, test.leq)
+		}
+		if got := SeqGT(test.a, test.b); got != test.gt {
+			t.Errorf("SeqGT(%#x, %#x) = %v, expected %v", test.a, test.b, got, test.gt)
+		}
+		if got := SeqGEQ(test.a, test.b); got != test.geq {
+			t.Errorf("SeqGEQ(%#x, %#x) = %v, expected %v", test.a, test.b, got, test.geq)
+		}
+	}
+}
+
+func TestSeqInRange(t *testing.T) {
+	tests := []struct {
+		seq, start, end uint32
+		expected        bool
+	}{
+		{100, 100, 200, true},
+		{199, 100, 200, true},
+		{200, 100, 200, false},
+		{99, 100, 200, false},
+		// 範囲が2^32を跨ぐ場合
+		{0xFFFFFFF0, 0xFFFFFF00, 0x100, true},
+		{0x50, 0xFFFFFF00, 0x100, true},
+		{0x100, 0xFFFFFF00, 0x100, false},
+		{0xFFFFFEFF, 0xFFFFFF00, 0x100, false},
+		// 空の範囲
+		{100, 100, 100, false},
+	}
+
+	for _, test := range tests {
+		if got := SeqInRange(test.seq, test.start, test.end); got != test.expected {
+			t.Errorf("SeqInRange(%#x, %#x, %#x) = %v, expected %v",
+				test.seq, test.start, test.end, got, test.expected)
+		}
+	}
+}
+
+func TestDataTransferAcrossWraparound(t *testing.T) {
+	localAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:8080")
+	remoteAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:9090")
+
+	tcb := NewTCB(localAddr, remoteAddr)
+	tcb.State = socket.StateEstablished
+	start := uint32(0xFFFFFF00)
+	tcb.SendNext = start
+	tcb.SendUnack = start
+	tcb.SendWindow = 65535
+	tcb.RecvNext = 1000
+	dt := NewDataTransfer(tcb)
+
+	// 3つ目のセグメントでシーケンス番号が0に戻る
+	for range 3 {
+		if _, err := dt.Send(make([]byte, 200)); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	if tcb.SendNext != 0x158 {
+		t.Fatalf("Expected SendNext 0x158, got %#x", tcb.SendNext)
+	}
+
+	ack := func(n uint32) error {
+		header := tcb.newHeader(0)
+		header.AckNumber = n
+		return dt.ReceiveAck(header)
+	}
+
+	// 0を跨いだACKで最初の2つが確認される
+	if err := ack(start + 400); err != nil {
+		t.Fatalf("ReceiveAck failed: %v", err)
+	}
+	if tcb.RetransmissionQueue.Size() != 1 || len(tcb.SendBuffer) != 200 {
+		t.Errorf("Expected 1 queued segment and 200 buffered bytes, got %d and %d",
+			tcb.RetransmissionQueue.Size(), len(tcb.SendBuffer))
+	}
+
+	// 確認済みの範囲より前や未送信の範囲へのACKは拒否する
+	if err := ack(start + 200); err == nil {
+		t.Error("Expected error for ACK before SND.UNA")
+	}
+	if err := ack(0x200); err == nil {
+		t.Error("Expected error for ACK beyond SND.NXT")
+	}
+
+	if err := ack(0x158); err != nil {
+		t.Fatalf("ReceiveAck failed: %v", err)
+	}
+	if tcb.RetransmissionQueue.Size() != 0 || len(tcb.SendBuffer) != 0 {
+		t.Errorf("Expected everything acknowledged, got %d queued segments and %d buffered bytes",
+			tcb.RetransmissionQueue.Size(), len(tcb.SendBuffer))
+	}
+}
+
+func TestStackTransferAcrossWraparound(t *testing.T) {
+	_, peerLink, segments, listener := listenWithPeer(t)
+
+	// 相手のISNを2^32の直前にしてハンドシェイクする
+	const isn = 0xFFFFFFFF - 1500
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	conn := establishWithISN(t, peerLink, segments, listener, peer, isn)
+
+	// 0を跨いでデータを送り、スタックのACKを処理する
+	peerTransfer := NewDataTransfer(peer)
+	message := make([]byte, 3000)
+	for i := range message {
+		message[i] = byte(i)
+	}
+	for sent := 0; sent < len(message); sent += 1000 {
+		chunk := message[sent : sent+1000]
+		header, err := peerTransfer.Send(chunk)
+		if err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		transmit(t, peerLink, peer, header, chunk)
+		if err := peerTransfer.ReceiveAck(receive(t, segments).header); err != nil {
+			t.Fatalf("ReceiveAck failed at offset %d: %v", sent, err)
+		}
+	}
+
+	if got := readN(t, conn, len(message)); string(got) != string(message) {
+		t.Error("Received data does not match")
+	}
+	if peer.SendNext != uint32(isn+1+len(message)) {
+		t.Errorf("Expected peer SendNext %#x, got %#x", uint32(isn+1+len(message)), peer.SendNext)
+	}
+	if peer.RetransmissionQueue.Size() != 0 {
+		t.Errorf("Expected all data acknowledged, %d segments queued", peer.RetransmissionQueue.Size())
+	}
+
+	// 返信も受け取れる（読み出しで開いたウィンドウの更新は読み飛ばす）
+	if _, err := conn.Send(context.Background(), []byte("pong")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	s := receive(t, segments)
+	for len(s.data) == 0 {
+		s = receive(t, segments)
+	}
+	if _, _, err := peerTransfer.Receive(s.header, s.data); err != nil || string(s.data) != "pong" {
+		t.Errorf("Expected %q, got %q (%v)", "pong", s.data, err)
+	}
+}
@@ -0,0 +1,567 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sasakihasuto/tinytcp/internal/ip"
+	"github.com/sasakihasuto/tinytcp/internal/link"
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+	"github.com/sasakihasuto/tinytcp/internal/socket"
+)
+
+// Stack settings
+const (
+	tickInterval       = 10 * time.Millisecond // タイマー処理の間隔
+	ephemeralPortFirst = 49152
+	ephemeralPortLast  = 65535
+)
+
+// DefaultMSL is the default maximum segment lifetime. Connections stay in
+// TIME_WAIT for twice this duration.
+const DefaultMSL = 30 * time.Second
+
+// Errors returned by Stack
+var (
+	ErrStackClosed    = errors.New("tcp: stack closed")
+	ErrListenerClosed = errors.New("tcp: listener closed")
+	ErrNoLocalAddress = errors.New("tcp: no local address for remote address family")
+	ErrNoPorts        = errors.New("tcp: no ephemeral ports available")
+	ErrNotConnected   = errors.New("tcp: connection not established")
+	ErrConnClosed     = errors.New("tcp: use of closed connection")
+)
+
+// Errors that also match the corresponding syscall.Errno with errors.Is,
+// like the errors of the net package
+var (
+	ErrConnRefused error = &errnoError{"tcp: connection refused", syscall.ECONNREFUSED}
+	ErrConnReset   error = &errnoError{"tcp: connection reset by peer", syscall.ECONNRESET}
+	ErrConnAborted error = &errnoError{"tcp: connection aborted", syscall.ECONNABORTED}
+)
+
+// ErrTimeout is returned when the retransmissions of a segment are exhausted
+var ErrTimeout error = timeoutError{}
+
+// errnoError is a sentinel error wrapping the errno a kernel TCP stack
+// would report
+type errnoError struct {
+	msg   string
+	errno syscall.Errno
+}
+
+func (e *errnoError) Error() string { return e.msg }
+func (e *errnoError) Unwrap() error { return e.errno }
+
+// timeoutError is the error of a connection whose retransmissions have been
+// exhausted. It satisfies net.Error with Timeout returning true and matches
+// syscall.ETIMEDOUT.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "tcp: connection timed out" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return false }
+func (timeoutError) Unwrap() error   { return syscall.ETIMEDOUT }
+
+// Stack implements the transport used by sockets
+var _ socket.Transport = (*Stack)(nil)
+
+// StackStats counts segments handled by a Stack
+type StackStats struct {
+	SegmentsReceived uint64
+	SegmentsSent     uint64
+	ChecksumErrors   uint64
+	MalformedDropped uint64
+	ResetsSent       uint64
+	ListenDrops      uint64 // バックログ超過で破棄したSYNや接続
+}
+
+// Stack owns the listeners and connections on one link. It decodes incoming
+// segments, routes them by four-tuple to a connection or listener and drives
+// the handshake, data transfer and close handlers.
+//
+// All TCBs of a stack are protected by a single mutex; incoming segments,
+// timers and application calls are serialized on it.
+type Stack struct {
+	link  link.LinkEndpoint
+	demux *ip.Demux
+	table *ConnTable
+	addrs []net.IP
+
+	mu        sync.Mutex
+	conns     map[*TCB]*Conn
+	listeners map[*TCB]*Listener
+	nextPort  int
+	stats     StackStats
+	closed    bool
+
+	// Retransmission settings applied to new connections
+	rto        time.Duration
+	minRTO     time.Duration
+	maxRTO     time.Duration
+	maxRetries int
+
+	msl            time.Duration // TIME_WAITは2*MSL続く
+	sendBufferSize int           // 新しい接続の送信バッファの上限
+	recvBufferSize int           // 新しい接続の受信バッファの上限
+
+	done chan struct{}
+}
+
+// NewStack creates a stack sending and receiving on ep.
+// addrs are the local addresses used for outgoing connections.
+func NewStack(ep link.LinkEndpoint, addrs ...net.IP) *Stack {
+	s := &Stack{
+		link:      ep,
+		demux:     ip.NewDemux(),
+		table:     NewConnTable(),
+		addrs:     addrs,
+		conns:     make(map[*TCB]*Conn),
+		listeners: make(map[*TCB]*Listener),
+		nextPort:  ephemeralPortFirst,
+		done:      make(chan struct{}),
+
+		rto:        DefaultRetransmissionTimeout,
+		minRTO:     DefaultMinRetransmissionTimeout,
+		maxRTO:     DefaultMaxRetransmissionTimeout,
+		maxRetries: DefaultMaxRetransmissionAttempts,
+		msl:        DefaultMSL,
+
+		sendBufferSize: DefaultSendBufferSize,
+		recvBufferSize: DefaultRecvBufferSize,
+	}
+	s.demux.Register(packet.ProtocolTCP, s.handleSegment)
+	ep.SetDeliver(func(pkt []byte) {
+		// 不正なIPパケットや未対応プロトコルは黙って破棄する
+		s.demux.Deliver(pkt)
+	})
+	go s.timerLoop()
+	return s
+}
+
+// Close stops the stack. Connections are dropped without notifying peers;
+// the link endpoint is left open.
+func (s *Stack) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.link.SetDeliver(nil)
+
+	for _, l := range s.listeners {
+		l.closeLocked()
+	}
+	for _, c := range s.conns {
+		c.failLocked(ErrStackClosed)
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the stack counters
+func (s *Stack) Stats() StackStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// SetRetransmissionTimeout sets the initial retransmission timeout of new
+// connections. It adapts to the measured round-trip time once segments are
+// acknowledged.
+func (s *Stack) SetRetransmissionTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rto = timeout
+}
+
+// SetRetransmissionTimeoutBounds sets the range the retransmission timeout
+// computed from round-trip time samples is clamped to. Backoff after
+// timeouts is only limited by maxTimeout.
+func (s *Stack) SetRetransmissionTimeoutBounds(minTimeout, maxTimeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.minRTO = minTimeout
+	s.maxRTO = maxTimeout
+}
+
+// SetMaxRetransmissionAttempts sets how many times new connections send a
+// segment before giving up with ErrTimeout
+func (s *Stack) SetMaxRetransmissionAttempts(maxAttempts int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxRetries = maxAttempts
+}
+
+// SetMSL sets the maximum segment lifetime. Connections entering TIME_WAIT
+// afterwards hold their four-tuple for 2*msl before they are freed.
+func (s *Stack) SetMSL(msl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.msl = msl
+}
+
+// SetSendBufferSize sets how much unacknowledged and unsent data new
+// connections buffer before Send blocks
+func (s *Stack) SetSendBufferSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendBufferSize = size
+}
+
+// SetRecvBufferSize sets how much unread data new connections buffer.
+// The receive window they advertise is the free part of this buffer.
+func (s *Stack) SetRecvBufferSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recvBufferSize = size
+}
+
+// Listen starts accepting connections on local. A nil IP listens on all
+// addresses and port 0 picks an ephemeral port. backlog bounds both the
+// half-open and the not yet accepted connections; a non-positive value
+// means DefaultBacklog.
+func (s *Stack) Listen(local *net.TCPAddr, backlog int) (socket.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrStackClosed
+	}
+	if backlog <= 0 {
+		backlog = DefaultBacklog
+	}
+
+	laddr := &net.TCPAddr{}
+	if local != nil {
+		*laddr = *local
+	}
+	if laddr.Port == 0 {
+		port, err := s.ephemeralPortLocked(func(port int) bool {
+			return s.table.LookupListener(AddrPort(&net.TCPAddr{IP: laddr.IP, Port: port})) == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		laddr.Port = port
+	}
+
+	tcb := NewTCB(laddr, nil)
+	tcb.State = socket.StateListen
+	if err := s.table.AddListener(tcb); err != nil {
+		return nil, err
+	}
+
+	l := newListener(s, tcb, backlog)
+	s.listeners[tcb] = l
+	return l, nil
+}
+
+// Connect starts an active open from local to remote and returns the
+// connection in SYN_SENT state. A nil local IP is replaced by a stack
+// address of the remote's family and port 0 by an ephemeral port.
+func (s *Stack) Connect(local, remote *net.TCPAddr) (*Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrStackClosed
+	}
+
+	laddr, err := s.localAddrLocked(local, remote)
+	if err != nil {
+		return nil, err
+	}
+	tcb := NewTCB(laddr, remote)
+	c := s.newConnLocked(tcb)
+
+	syn, err := c.handshake.StartClient()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.table.Add(tcb); err != nil {
+		return nil, err
+	}
+	s.conns[tcb] = c
+	s.outputLocked(tcb, syn, nil)
+	return c, nil
+}
+
+// Dial performs an active open and blocks until the connection is
+// established. It fails with ErrConnRefused if the peer answers with RST,
+// ErrTimeout once the SYN retransmissions are exhausted, or ctx.Err().
+func (s *Stack) Dial(ctx context.Context, local, remote *net.TCPAddr) (socket.Conn, error) {
+	c, err := s.Connect(local, remote)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.waitEstablished(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// localAddrLocked fills in the local IP and port of an outgoing connection
+func (s *Stack) localAddrLocked(local, remote *net.TCPAddr) (*net.TCPAddr, error) {
+	laddr := &net.TCPAddr{}
+	if local != nil {
+		*laddr = *local
+	}
+
+	if laddr.IP == nil || laddr.IP.IsUnspecified() {
+		laddr.IP = nil
+		for _, addr := range s.addrs {
+			if (addr.To4() != nil) == (remote.IP.To4() != nil) {
+				laddr.IP = addr
+				break
+			}
+		}
+		if laddr.IP == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNoLocalAddress, remote.IP)
+		}
+	}
+
+	if laddr.Port == 0 {
+		port, err := s.ephemeralPortLocked(func(port int) bool {
+			candidate := &net.TCPAddr{IP: laddr.IP, Port: port}
+			return s.table.Lookup(NewFourTuple(candidate, remote)) == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		laddr.Port = port
+	}
+	return laddr, nil
+}
+
+// ephemeralPortLocked returns the next ephemeral port accepted by free
+func (s *Stack) ephemeralPortLocked(free func(port int) bool) (int, error) {
+	for range ephemeralPortLast - ephemeralPortFirst + 1 {
+		port := s.nextPort
+		s.nextPort++
+		if s.nextPort > ephemeralPortLast {
+			s.nextPort = ephemeralPortFirst
+		}
+		if free(port) {
+			return port, nil
+		}
+	}
+	return 0, ErrNoPorts
+}
+
+// newConnLocked wraps a TCB configured for this stack into a Conn
+func (s *Stack) newConnLocked(tcb *TCB) *Conn {
+	// リンクのMTUに収まるMSSを広告する
+	overhead := ip.IPv4MinHeaderLength + packet.MinHeaderLength
+	if tcb.LocalAddr.IP.To4() == nil {
+		overhead = ip.IPv6HeaderLength + packet.MinHeaderLength
+	}
+	if mss := s.link.MTU() - overhead; mss > 0 && mss < int(tcb.MSS) {
+		tcb.MSS = uint16(mss)
+	}
+	tcb.RetransmissionTimeout = s.rto
+	tcb.MinRetransmissionTimeout = s.minRTO
+	tcb.MaxRetransmissionTimeout = s.maxRTO
+	tcb.MaxRetransmissionAttempts = s.maxRetries
+	tcb.SendBufferSize = s.sendBufferSize
+	tcb.RecvBufferSize = s.recvBufferSize
+	tcb.RecvWindow = uint32(s.recvBufferSize)
+	tcb.RecvWindowShift = windowShift(s.recvBufferSize)
+
+	c := &Conn{
+		stack:     s,
+		tcb:       tcb,
+		handshake: NewThreeWayHandshake(tcb),
+		transfer:  NewDataTransfer(tcb),
+		closer:    NewFourWayHandshake(tcb),
+	}
+	c.cond = sync.NewCond(&s.mu)
+	return c
+}
+
+// removeLocked forgets a connection that reached CLOSED
+func (s *Stack) removeLocked(c *Conn) {
+	s.table.Remove(c.tcb)
+	delete(s.conns, c.tcb)
+	if c.listener != nil {
+		delete(c.listener.synQueue, c)
+	}
+	c.cond.Broadcast()
+}
+
+// handleSegment is the ip.Handler for TCP
+func (s *Stack) handleSegment(src, dst net.IP, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.stats.SegmentsReceived++
+
+	header, data, err := packet.Unmarshal(payload)
+	if err != nil {
+		s.stats.MalformedDropped++
+		return
+	}
+
+	local := &net.TCPAddr{IP: dst, Port: int(header.DestinationPort)}
+	remote := &net.TCPAddr{IP: src, Port: int(header.SourcePort)}
+	tcb := s.table.Lookup(NewFourTuple(local, remote))
+
+	// チェックサムはここで受信したバイト列に対して一度だけ検証し、
+	// 宛先のコネクションかリスナーのTCBでも数える（TCBのVerifyChecksumは使わない）
+	if err := packet.VerifySegment(src, dst, payload); err != nil {
+		s.stats.ChecksumErrors++
+		owner := tcb
+		if owner == nil {
+			owner = s.table.LookupListener(AddrPort(local))
+		}
+		if owner != nil {
+			owner.checksumError(err)
+		}
+		return
+	}
+
+	var prev *Conn
+	if tcb != nil {
+		c := s.conns[tcb]
+		if !c.reusableLocked(header) {
+			c.handleSegment(header, data)
+			return
+		}
+		// TIME_WAITの4タプルはリスナーがSYNを受け入れたときだけ明け渡す（RFC 6191）
+		prev = c
+	}
+
+	if header.HasFlag(packet.FlagSYN) && !header.HasFlag(packet.FlagACK) && !header.HasFlag(packet.FlagRST) {
+		if ltcb := s.table.LookupListener(AddrPort(local)); ltcb != nil {
+			s.listeners[ltcb].handleSyn(local, remote, header, prev)
+			return
+		}
+	}
+
+	if prev != nil {
+		// 受け入れるリスナーがなければTIME_WAITを保ったままSYNを捨てる
+		return
+	}
+	s.sendResetLocked(local, remote, header, data)
+}
+
+// sendResetLocked answers a segment that belongs to no connection (RFC 793 3.4)
+func (s *Stack) sendResetLocked(local, remote *net.TCPAddr, header *packet.TCPHeader, data []byte) {
+	if header.HasFlag(packet.FlagRST) {
+		return
+	}
+
+	rst := packet.NewTCPHeader(uint16(local.Port), uint16(remote.Port))
+	if header.HasFlag(packet.FlagACK) {
+		rst.SetFlag(packet.FlagRST)
+		rst.SequenceNumber = header.AckNumber
+	} else {
+		rst.SetFlag(packet.FlagRST | packet.FlagACK)
+		rst.AckNumber = header.SequenceNumber + segmentLength(header, data)
+	}
+
+	if s.transmitLocked(local.IP, remote.IP, rst, nil) == nil {
+		s.stats.ResetsSent++
+	}
+}
+
+// segmentLength returns the sequence space occupied by a segment
+func segmentLength(header *packet.TCPHeader, data []byte) uint32 {
+	n := uint32(len(data))
+	if header.HasFlag(packet.FlagSYN) {
+		n++
+	}
+	if header.HasFlag(packet.FlagFIN) {
+		n++
+	}
+	return n
+}
+
+// outputLocked sends a segment of a connection
+func (s *Stack) outputLocked(tcb *TCB, header *packet.TCPHeader, data []byte) {
+	// 送信失敗はパケットロスとして扱い、再送に任せる
+	s.transmitLocked(tcb.LocalAddr.IP, tcb.RemoteAddr.IP, header, data)
+}
+
+// transmitLocked checksums, encapsulates and writes a segment to the link
+func (s *Stack) transmitLocked(src, dst net.IP, header *packet.TCPHeader, data []byte) error {
+	if err := header.SetChecksum(src, dst, data); err != nil {
+		return err
+	}
+	b, err := header.Marshal()
+	if err != nil {
+		return err
+	}
+
+	packets, err := ip.Encapsulate(src, dst, packet.ProtocolTCP, append(b, data...), s.link.MTU())
+	if err != nil {
+		return err
+	}
+	for _, pkt := range packets {
+		if err := s.link.WritePacket(pkt); err != nil {
+			return err
+		}
+	}
+	s.stats.SegmentsSent++
+	return nil
+}
+
+// timerLoop runs the retransmission timers of all connections
+func (s *Stack) timerLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.tick()
+		}
+	}
+}
+
+// tick retransmits timed-out segments, sends zero window probes and drops
+// connections whose retransmissions are exhausted
+func (s *Stack) tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, c := range s.conns {
+		if c.tcb.State == socket.StateTimeWait {
+			// 2*MSL経過したら4タプルを解放する
+			if !now.Before(c.timeWaitUntil) {
+				c.tcb.State = socket.StateClosed
+				s.removeLocked(c)
+			}
+			continue
+		}
+		if !c.persistAt.IsZero() {
+			// ゼロウィンドウの間は再送タイマーの代わりにパーシストタイマーがプローブを送る
+			if !now.Before(c.persistAt) {
+				c.probeLocked(now)
+			}
+			continue
+		}
+		if c.tcb.RetransmissionQueue.HasExpired(c.tcb.RetransmissionTimeout, c.tcb.MaxRetransmissionAttempts) {
+			c.failLocked(ErrTimeout)
+			continue
+		}
+		entries, _ := c.transfer.CheckRetransmissions()
+		for _, entry := range entries {
+			// 再送時は最新のACK番号、ウィンドウ、タイムスタンプを載せる
+			c.tcb.refreshHeader(entry.Header)
+			s.outputLocked(c.tcb, entry.Header, entry.Data)
+		}
+	}
+}
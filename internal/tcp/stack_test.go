@@ -0,0 +1,1572 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sasakihasuto/tinytcp/internal/ip"
+	"github.com/sasakihasuto/tinytcp/internal/link"
+	"github.com/sasakihasuto/tinytcp/internal/packet"
+	"github.com/sasakihasuto/tinytcp/internal/socket"
+)
+
+var (
+	stackClientIP = net.IPv4(10, 0, 0, 1)
+	stackServerIP = net.IPv4(10, 0, 0, 2)
+)
+
+// newStackPair creates two stacks connected by an in-memory pipe
+func newStackPair(t *testing.T) (*Stack, *Stack) {
+	t.Helper()
+
+	clientLink, serverLink := link.NewPipe(1500)
+	client := NewStack(clientLink, stackClientIP)
+	server := NewStack(serverLink, stackServerIP)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+		clientLink.Close()
+		serverLink.Close()
+	})
+	return client, server
+}
+
+// listenWithPeer starts a stack listening on port 80 of stackServerIP and
+// returns it with the peer's end of the link and the segments it receives
+func listenWithPeer(t *testing.T) (*Stack, link.LinkEndpoint, <-chan segment, socket.Listener) {
+	t.Helper()
+
+	stackLink, peerLink := link.NewPipe(1500)
+	stack := NewStack(stackLink, stackServerIP)
+	t.Cleanup(func() {
+		stack.Close()
+		stackLink.Close()
+		peerLink.Close()
+	})
+	segments := attach(t, peerLink)
+
+	listener, err := stack.Listen(&net.TCPAddr{IP: stackServerIP, Port: 80}, 0)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	return stack, peerLink, segments, listener
+}
+
+// waitForState polls until the connection reaches the expected state
+func waitForState(t *testing.T, c *Conn, expected socket.SocketState) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for c.State() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected state %s, got %s", expected, c.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// accept waits for the next connection of a listener
+func accept(t *testing.T, l socket.Listener) *Conn {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, err := l.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	return c.(*Conn)
+}
+
+// readN reads exactly n bytes from the connection
+func readN(t *testing.T, c *Conn, n int) []byte {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	data := make([]byte, n)
+	for read := 0; read < n; {
+		m, err := c.Receive(ctx, data[read:])
+		if err != nil {
+			t.Fatalf("Receive failed after %d of %d bytes: %v", read, n, err)
+		}
+		read += m
+	}
+	return data
+}
+
+func TestStackConnectTransferClose(t *testing.T) {
+	client, server := newStackPair(t)
+
+	listener, err := server.Listen(&net.TCPAddr{IP: stackServerIP, Port: 80}, 0)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	conn, err := client.Connect(nil, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if conn.LocalAddr().Port < ephemeralPortFirst {
+		t.Errorf("Expected ephemeral port, got %d", conn.LocalAddr().Port)
+	}
+
+	accepted := accept(t, listener)
+	waitForState(t, conn, socket.StateEstablished)
+	if accepted.RemoteAddr().Port != conn.LocalAddr().Port {
+		t.Errorf("Expected remote port %d, got %d", conn.LocalAddr().Port, accepted.RemoteAddr().Port)
+	}
+
+	// MSSを超えるデータは分割して送られる
+	message := make([]byte, 3000)
+	for i := range message {
+		message[i] = byte(i)
+	}
+	if n, err := conn.Send(context.Background(), message); err != nil || n != len(message) {
+		t.Fatalf("Send failed: n=%d err=%v", n, err)
+	}
+	if got := readN(t, accepted, len(message)); string(got) != string(message) {
+		t.Error("Received data does not match")
+	}
+
+	reply := []byte("pong")
+	if _, err := accepted.Send(context.Background(), reply); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := readN(t, conn, len(reply)); string(got) != "pong" {
+		t.Errorf("Expected %q, got %q", "pong", got)
+	}
+
+	// アクティブクローズ
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	waitForState(t, accepted, socket.StateCloseWait)
+	waitForState(t, conn, socket.StateFinWait2)
+
+	// 相手のFIN以降はio.EOF
+	if _, err := accepted.Receive(context.Background(), make([]byte, 16)); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	if _, err := conn.Send(context.Background(), reply); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Expected ErrConnClosed after Close, got %v", err)
+	}
+
+	if err := accepted.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	waitForState(t, accepted, socket.StateClosed)
+	waitForState(t, conn, socket.StateTimeWait)
+
+	if server.table.Len() != 0 {
+		t.Errorf("Expected closed connection to be removed, %d left", server.table.Len())
+	}
+	if stats := server.Stats(); stats.ChecksumErrors != 0 || stats.ResetsSent != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestStackResetsUnknownPort(t *testing.T) {
+	stackLink, peerLink := link.NewPipe(1500)
+	defer stackLink.Close()
+	defer peerLink.Close()
+	stack := NewStack(stackLink, stackServerIP)
+	defer stack.Close()
+	segments := attach(t, peerLink)
+
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 81})
+
+	// SYNにはRST+ACKが返る
+	syn, _ := NewThreeWayHandshake(peer).StartClient()
+	transmit(t, peerLink, peer, syn, nil)
+
+	s := receive(t, segments)
+	if !s.header.HasFlag(packet.FlagRST|packet.FlagACK) || s.header.AckNumber != syn.SequenceNumber+1 {
+		t.Errorf("Expected RST+ACK acking %d, got %s", syn.SequenceNumber+1, s.header)
+	}
+	if s.header.SequenceNumber != 0 {
+		t.Errorf("Expected sequence number 0, got %d", s.header.SequenceNumber)
+	}
+
+	// ACK付きのセグメントにはACK番号をシーケンス番号としたRSTが返る
+	ack := peer.newHeader(packet.FlagACK)
+	ack.SequenceNumber = 100
+	ack.AckNumber = 5000
+	transmit(t, peerLink, peer, ack, []byte("data"))
+
+	s = receive(t, segments)
+	if s.header.Flags != packet.FlagRST || s.header.SequenceNumber != 5000 {
+		t.Errorf("Expected RST with seq 5000, got %s", s.header)
+	}
+
+	// RSTにはRSTを返さない
+	rst := peer.newHeader(packet.FlagRST)
+	transmit(t, peerLink, peer, rst, nil)
+	select {
+	case s := <-segments:
+		t.Errorf("Unexpected reply to RST: %s", s.header)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if stats := stack.Stats(); stats.ResetsSent != 2 {
+		t.Errorf("Expected 2 resets sent, got %d", stats.ResetsSent)
+	}
+}
+
+func TestStackDropsCorruptedSegments(t *testing.T) {
+	stack, peerLink, segments, listener := listenWithPeer(t)
+
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	syn, _ := NewThreeWayHandshake(peer).StartClient()
+	syn.SetChecksum(peer.LocalAddr.IP, peer.RemoteAddr.IP, nil)
+	b, _ := syn.Marshal()
+	b[4] ^= 0xff // チェックサム計算後にシーケンス番号を壊す
+
+	ipHeader := ip.NewIPv4Header(peer.LocalAddr.IP, peer.RemoteAddr.IP, packet.ProtocolTCP, len(b))
+	ipBytes, _ := ipHeader.Marshal()
+	if err := peerLink.WritePacket(append(ipBytes, b...)); err != nil {
+		t.Fatalf("Failed to write packet: %v", err)
+	}
+
+	select {
+	case s := <-segments:
+		t.Errorf("Unexpected reply to corrupted segment: %s", s.header)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if stats := stack.Stats(); stats.ChecksumErrors != 1 {
+		t.Errorf("Expected 1 checksum error, got %d", stats.ChecksumErrors)
+	}
+	stack.mu.Lock()
+	listenErrors := listener.(*Listener).tcb.ChecksumErrors
+	stack.mu.Unlock()
+	if listenErrors != 1 {
+		t.Errorf("Expected 1 checksum error on the listener, got %d", listenErrors)
+	}
+
+	// 確立済みの接続宛ての破損セグメントはその接続のTCBで数える
+	peer = NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40001}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	conn := establishWithPeer(t, peerLink, segments, listener, peer)
+	data, _ := NewDataTransfer(peer).Send([]byte("hello"))
+	data.SetChecksum(peer.LocalAddr.IP, peer.RemoteAddr.IP, []byte("hello"))
+	b, _ = data.Marshal()
+	b = append(b, "hellO"...)
+	ipHeader = ip.NewIPv4Header(peer.LocalAddr.IP, peer.RemoteAddr.IP, packet.ProtocolTCP, len(b))
+	ipBytes, _ = ipHeader.Marshal()
+	if err := peerLink.WritePacket(append(ipBytes, b...)); err != nil {
+		t.Fatalf("Failed to write packet: %v", err)
+	}
+	select {
+	case s := <-segments:
+		t.Errorf("Unexpected reply to corrupted segment: %s", s.header)
+	case <-time.After(50 * time.Millisecond):
+	}
+	stack.mu.Lock()
+	connErrors := conn.tcb.ChecksumErrors
+	stack.mu.Unlock()
+	if connErrors != 1 {
+		t.Errorf("Expected 1 checksum error on the connection, got %d", connErrors)
+	}
+}
+
+func TestStackAcceptsPaddedOptions(t *testing.T) {
+	stack, peerLink, segments, _ := listenWithPeer(t)
+
+	// EOLの後ろに必要以上のパディングを置いたSYN（データオフセット8）
+	syn := packet.NewTCPHeader(40000, 80)
+	syn.SetFlag(packet.FlagSYN)
+	syn.SequenceNumber = 1000
+	syn.AddOption(packet.MSSOption{MSS: 1460})
+	syn.AddOption(packet.EOLOption{})
+	b, _ := syn.Marshal()
+	b = append(b, 0, 0, 0, 0)
+	b[12] = 8<<4 | b[12]&0x0f
+	sum, _ := packet.PseudoHeaderSum(stackClientIP, stackServerIP, packet.ProtocolTCP, len(b))
+	checksum := packet.InternetChecksum(b, sum)
+	b[16], b[17] = byte(checksum>>8), byte(checksum)
+
+	ipHeader := ip.NewIPv4Header(stackClientIP, stackServerIP, packet.ProtocolTCP, len(b))
+	ipBytes, _ := ipHeader.Marshal()
+	if err := peerLink.WritePacket(append(ipBytes, b...)); err != nil {
+		t.Fatalf("Failed to write packet: %v", err)
+	}
+
+	s := receive(t, segments)
+	if !s.header.HasFlag(packet.FlagSYN|packet.FlagACK) || s.header.AckNumber != 1001 {
+		t.Errorf("Expected SYN-ACK of 1001, got %s", s.header)
+	}
+	if stats := stack.Stats(); stats.ChecksumErrors != 0 {
+		t.Errorf("Expected no checksum errors, got %d", stats.ChecksumErrors)
+	}
+}
+
+func TestStackBulkTransferOverIPv6(t *testing.T) {
+	clientIP, serverIP := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	clientLink, serverLink := link.NewPipe(1500)
+	client := NewStack(clientLink, clientIP)
+	server := NewStack(serverLink, serverIP)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+		clientLink.Close()
+		serverLink.Close()
+	})
+
+	listener, err := server.Listen(&net.TCPAddr{IP: serverIP, Port: 80}, 0)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	dialed, err := client.Dial(context.Background(), nil, &net.TCPAddr{IP: serverIP, Port: 80})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn := dialed.(*Conn)
+	accepted := accept(t, listener)
+
+	// IPv6はフラグメントしないので、オプション込みでMTUに収まらないと届かない
+	message := make([]byte, 5000)
+	for i := range message {
+		message[i] = byte(i)
+	}
+	if _, err := conn.Send(context.Background(), message); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := readN(t, accepted, len(message)); string(got) != string(message) {
+		t.Error("Received data does not match")
+	}
+}
+
+func TestStackDial(t *testing.T) {
+	client, server := newStackPair(t)
+
+	// socket.TinySocket経由でハンドシェイクを行う
+	listenSock := socket.NewSocketWithTransport(server)
+	if err := listenSock.Listen(":80"); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listenSock.Close()
+
+	sock := socket.NewSocketWithTransport(client)
+	if err := sock.Connect("10.0.0.2:80"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if sock.State() != socket.StateEstablished {
+		t.Errorf("Expected state to be ESTABLISHED, got %v", sock.State())
+	}
+	if !sock.LocalAddr().IP.Equal(stackClientIP) {
+		t.Errorf("Expected local IP %s, got %s", stackClientIP, sock.LocalAddr().IP)
+	}
+
+	accepted, err := listenSock.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	if accepted.State() != socket.StateEstablished {
+		t.Errorf("Expected accepted state to be ESTABLISHED, got %v", accepted.State())
+	}
+	if accepted.RemoteAddr().Port != sock.LocalAddr().Port {
+		t.Errorf("Expected remote port %d, got %d", sock.LocalAddr().Port, accepted.RemoteAddr().Port)
+	}
+}
+
+func TestStackDialRefused(t *testing.T) {
+	client, _ := newStackPair(t)
+
+	_, err := client.Dial(context.Background(), nil, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	if !errors.Is(err, ErrConnRefused) {
+		t.Errorf("Expected ErrConnRefused, got %v", err)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("Expected error matching ECONNREFUSED, got %v", err)
+	}
+	if client.table.Len() != 0 {
+		t.Errorf("Expected refused connection to be removed, %d left", client.table.Len())
+	}
+}
+
+func TestStackDialTimeout(t *testing.T) {
+	stackLink, peerLink := link.NewPipe(1500)
+	defer stackLink.Close()
+	defer peerLink.Close()
+	stack := NewStack(stackLink, stackClientIP)
+	defer stack.Close()
+	segments := attach(t, peerLink)
+
+	stack.SetRetransmissionTimeout(20 * time.Millisecond)
+	stack.SetMaxRetransmissionAttempts(3)
+
+	// 応答しない相手へのSYNは再送の末タイムアウトする
+	_, err := stack.Dial(context.Background(), nil, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("Expected a net.Error timeout, got %v", err)
+	}
+
+	first := receive(t, segments)
+	for i := 1; i < 3; i++ {
+		s := receive(t, segments)
+		if !s.header.HasFlag(packet.FlagSYN) || s.header.SequenceNumber != first.header.SequenceNumber {
+			t.Errorf("Expected retransmitted SYN, got %s", s.header)
+		}
+	}
+	select {
+	case s := <-segments:
+		t.Errorf("Unexpected segment after giving up: %s", s.header)
+	default:
+	}
+}
+
+func TestStackDialCanceled(t *testing.T) {
+	stackLink, peerLink := link.NewPipe(1500)
+	defer stackLink.Close()
+	defer peerLink.Close()
+	stack := NewStack(stackLink, stackClientIP)
+	defer stack.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := stack.Dial(ctx, nil, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if stack.table.Len() != 0 {
+		t.Errorf("Expected canceled connection to be removed, %d left", stack.table.Len())
+	}
+}
+
+func TestListenerAcceptQueueFull(t *testing.T) {
+	client, server := newStackPair(t)
+
+	listener, err := server.Listen(&net.TCPAddr{Port: 80}, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	remote := &net.TCPAddr{IP: stackServerIP, Port: 80}
+
+	// 1つ目はAccept待ちキューに入る
+	first, err := client.Dial(context.Background(), nil, remote)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+
+	// 2つ目はハンドシェイク完了時にキューが満杯のためRSTされる
+	// RSTがDialの復帰より先に届くこともある
+	second, err := client.Dial(context.Background(), nil, remote)
+	if err == nil {
+		waitForState(t, second.(*Conn), socket.StateClosed)
+	} else if !errors.Is(err, ErrConnReset) {
+		t.Fatalf("Expected ErrConnReset, got %v", err)
+	}
+	if stats := server.Stats(); stats.ListenDrops != 1 {
+		t.Errorf("Expected 1 listen drop, got %d", stats.ListenDrops)
+	}
+
+	accepted := accept(t, listener)
+	if accepted.RemoteAddr().Port != first.LocalAddr().Port {
+		t.Errorf("Expected first connection, got remote port %d", accepted.RemoteAddr().Port)
+	}
+	if first.State() != socket.StateEstablished {
+		t.Errorf("Expected first connection to stay ESTABLISHED, got %s", first.State())
+	}
+}
+
+func TestListenerSynQueueFull(t *testing.T) {
+	stackLink, peerLink := link.NewPipe(1500)
+	defer stackLink.Close()
+	defer peerLink.Close()
+	stack := NewStack(stackLink, stackServerIP)
+	defer stack.Close()
+	segments := attach(t, peerLink)
+
+	if _, err := stack.Listen(&net.TCPAddr{Port: 80}, 1); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	// ハンドシェイクを完了しない相手からのSYN
+	for port := 40000; port < 40002; port++ {
+		peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: port}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+		syn, _ := NewThreeWayHandshake(peer).StartClient()
+		transmit(t, peerLink, peer, syn, nil)
+	}
+
+	s := receive(t, segments)
+	if !s.header.HasFlag(packet.FlagSYN|packet.FlagACK) || s.header.DestinationPort != 40000 {
+		t.Errorf("Expected SYN-ACK to port 40000, got %s", s.header)
+	}
+	select {
+	case s := <-segments:
+		t.Errorf("Expected second SYN to be dropped, got %s", s.header)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if stats := stack.Stats(); stats.ListenDrops != 1 {
+		t.Errorf("Expected 1 listen drop, got %d", stats.ListenDrops)
+	}
+}
+
+func TestListenerCloseResetsPending(t *testing.T) {
+	client, server := newStackPair(t)
+
+	listener, err := server.Listen(&net.TCPAddr{Port: 0}, 0)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	if listener.Addr().Port < ephemeralPortFirst {
+		t.Errorf("Expected ephemeral port, got %d", listener.Addr().Port)
+	}
+
+	conn, err := client.Dial(context.Background(), nil, &net.TCPAddr{IP: stackServerIP, Port: listener.Addr().Port})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+
+	// Acceptされていない接続はリスナーのクローズでRSTされる
+	listener.Close()
+	waitForState(t, conn.(*Conn), socket.StateClosed)
+	if _, err := listener.Accept(context.Background()); !errors.Is(err, ErrListenerClosed) {
+		t.Errorf("Expected ErrListenerClosed, got %v", err)
+	}
+}
+
+func TestConnSendBlocksWhenBufferFull(t *testing.T) {
+	stackLink, peerLink := link.NewPipe(1500)
+	defer stackLink.Close()
+	defer peerLink.Close()
+	stack := NewStack(stackLink, stackClientIP)
+	defer stack.Close()
+	segments := attach(t, peerLink)
+
+	// 相手側はハンドラを直接使ってハンドシェイクする
+	dialed := make(chan socket.Conn, 1)
+	go func() {
+		c, err := stack.Dial(context.Background(), nil, &net.TCPAddr{IP: stackServerIP, Port: 80})
+		if err != nil {
+			t.Errorf("Dial failed: %v", err)
+		}
+		dialed <- c
+	}()
+
+	syn := receive(t, segments)
+	peer := NewTCB(&net.TCPAddr{IP: stackServerIP, Port: 80}, &net.TCPAddr{IP: stackClientIP, Port: int(syn.header.SourcePort)})
+	peer.State = socket.StateListen
+	peerHandshake := NewThreeWayHandshake(peer)
+	synAck, err := peerHandshake.HandleSyn(syn.header)
+	if err != nil {
+		t.Fatalf("Failed to handle SYN: %v", err)
+	}
+	transmit(t, peerLink, peer, synAck, nil)
+	if err := peerHandshake.HandleAck(receive(t, segments).header); err != nil {
+		t.Fatalf("Failed to handle final ACK: %v", err)
+	}
+	conn := (<-dialed).(*Conn)
+
+	stack.mu.Lock()
+	conn.tcb.SendBufferSize = 100
+	stack.mu.Unlock()
+
+	// ACKが返らないのでバッファが埋まった時点でブロックする
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	n, err := conn.Send(ctx, make([]byte, 250))
+	if n != 100 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected 100 bytes and deadline exceeded, got %d and %v", n, err)
+	}
+
+	// ACKでバッファが空くと送信を再開できる
+	peerDT := NewDataTransfer(peer)
+	data := receive(t, segments)
+	_, ack, err := peerDT.Receive(data.header, data.data)
+	if err != nil {
+		t.Fatalf("Failed to receive data: %v", err)
+	}
+	transmit(t, peerLink, peer, ack, nil)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if n, err := conn.Send(ctx, make([]byte, 100)); n != 100 || err != nil {
+		t.Errorf("Expected 100 bytes after ACK, got %d and %v", n, err)
+	}
+}
+
+func TestConnHalfClose(t *testing.T) {
+	client, server := newStackPair(t)
+
+	listener, err := server.Listen(&net.TCPAddr{IP: stackServerIP, Port: 80}, 0)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	dialed, err := client.Dial(context.Background(), nil, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn := dialed.(*Conn)
+	accepted := accept(t, listener)
+
+	// 送信側だけを閉じる
+	if _, err := conn.Send(context.Background(), []byte("request")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite failed: %v", err)
+	}
+	if got := readN(t, accepted, 7); string(got) != "request" {
+		t.Errorf("Expected %q, got %q", "request", got)
+	}
+	if _, err := accepted.Receive(context.Background(), make([]byte, 16)); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	if _, err := conn.Send(context.Background(), []byte("more")); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Expected ErrConnClosed after CloseWrite, got %v", err)
+	}
+
+	// CLOSE_WAITでもアプリケーションが閉じるまで送信できる
+	waitForState(t, accepted, socket.StateCloseWait)
+	if _, err := accepted.Send(context.Background(), []byte("response")); err != nil {
+		t.Fatalf("Send in CLOSE_WAIT failed: %v", err)
+	}
+	if got := readN(t, conn, 8); string(got) != "response" {
+		t.Errorf("Expected %q, got %q", "response", got)
+	}
+	waitForState(t, conn, socket.StateFinWait2)
+
+	if err := accepted.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := conn.Receive(context.Background(), make([]byte, 16)); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	waitForState(t, accepted, socket.StateClosed)
+	waitForState(t, conn, socket.StateTimeWait)
+}
+
+func TestConnCloseRead(t *testing.T) {
+	client, server := newStackPair(t)
+
+	listener, err := server.Listen(&net.TCPAddr{IP: stackServerIP, Port: 80}, 0)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	dialed, err := client.Dial(context.Background(), nil, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn := dialed.(*Conn)
+	accepted := accept(t, listener)
+
+	// ブロック中のReceiveはCloseReadでio.EOFを返す
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Receive(context.Background(), make([]byte, 16))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := conn.CloseRead(); err != nil {
+		t.Fatalf("CloseRead failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Errorf("Expected io.EOF, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Receive was not woken up by CloseRead")
+	}
+
+	// 以降に届いたデータはACKした上で破棄される
+	if _, err := accepted.Send(context.Background(), []byte("ignored")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for accepted.TCB().RetransmissionQueue.Size() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Data sent after CloseRead was not acknowledged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 送信側は影響を受けない
+	if _, err := conn.Send(context.Background(), []byte("still open")); err != nil {
+		t.Fatalf("Send after CloseRead failed: %v", err)
+	}
+	if got := readN(t, accepted, 10); string(got) != "still open" {
+		t.Errorf("Expected %q, got %q", "still open", got)
+	}
+}
+
+// closeToTimeWait establishes a connection from peer and closes it actively
+// from the stack side, returning the connection in TIME_WAIT and the
+// peer's FIN
+func closeToTimeWait(t *testing.T, peerLink link.LinkEndpoint, segments <-chan segment, listener socket.Listener, peer *TCB) (*Conn, *packet.TCPHeader) {
+	t.Helper()
+
+	conn := establishWithPeer(t, peerLink, segments, listener, peer)
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	peerCloser := NewFourWayHandshake(peer)
+	finAck, err := peerCloser.HandleFin(receive(t, segments).header)
+	if err != nil {
+		t.Fatalf("HandleFin failed: %v", err)
+	}
+	transmit(t, peerLink, peer, finAck, nil)
+	fin, _ := peerCloser.CloseFromCloseWait()
+	transmit(t, peerLink, peer, fin, nil)
+	receive(t, segments)
+	waitForState(t, conn, socket.StateTimeWait)
+	return conn, fin
+}
+
+func TestTimeWait(t *testing.T) {
+	_, peerLink, segments, listener := listenWithPeer(t)
+
+	// スタック側からアクティブクローズしてTIME_WAITに入る
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	conn, fin := closeToTimeWait(t, peerLink, segments, listener, peer)
+
+	// 再送されたFINにはACKし直す
+	transmit(t, peerLink, peer, fin, nil)
+	s := receive(t, segments)
+	if !s.header.HasFlag(packet.FlagACK) || s.header.AckNumber != fin.SequenceNumber+1 {
+		t.Errorf("Expected ACK of retransmitted FIN, got %s", s.header)
+	}
+
+	// TIME_WAIT中のRSTは無視する（RFC 1337）
+	rst := peer.newHeader(packet.FlagRST)
+	rst.SequenceNumber = peer.SendNext
+	transmit(t, peerLink, peer, rst, nil)
+
+	// 前の接続より古いタイムスタンプのSYNは黙って捨てる
+	finTS := fin.FindOption(packet.OptionKindTimestamps).(packet.TimestampsOption).Value
+	oldSyn := packet.NewTCPHeader(40000, 80)
+	oldSyn.SetFlag(packet.FlagSYN)
+	oldSyn.SequenceNumber = peer.SendNext + 1000
+	oldSyn.AddOption(packet.TimestampsOption{Value: finTS - 1})
+	transmit(t, peerLink, peer, oldSyn, nil)
+	select {
+	case s := <-segments:
+		t.Errorf("Unexpected reply to old SYN: %s", s.header)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if conn.State() != socket.StateTimeWait {
+		t.Fatalf("Expected state TIME_WAIT, got %s", conn.State())
+	}
+
+	// より新しいタイムスタンプのSYNは4タプルを再利用できる（RFC 6191）
+	newSyn := packet.NewTCPHeader(40000, 80)
+	newSyn.SetFlag(packet.FlagSYN)
+	newSyn.SequenceNumber = peer.SendNext - 1000
+	newSyn.AddOption(packet.TimestampsOption{Value: finTS + 1})
+	transmit(t, peerLink, peer, newSyn, nil)
+	s = receive(t, segments)
+	if !s.header.HasFlag(packet.FlagSYN|packet.FlagACK) || s.header.AckNumber != newSyn.SequenceNumber+1 {
+		t.Fatalf("Expected SYN-ACK for new SYN, got %s", s.header)
+	}
+	waitForState(t, conn, socket.StateClosed)
+
+	// 新しい接続のISNは前の接続のシーケンス空間より後ろになる
+	if !SeqGT(s.header.SequenceNumber, conn.TCB().SendNext) {
+		t.Errorf("Expected ISN after %d, got %d", conn.TCB().SendNext, s.header.SequenceNumber)
+	}
+}
+
+func TestTimeWaitReuseWithoutTimestamps(t *testing.T) {
+	_, peerLink, segments, listener := listenWithPeer(t)
+
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	conn, _ := closeToTimeWait(t, peerLink, segments, listener, peer)
+
+	// タイムスタンプのないSYNはシーケンス番号で判断し、RCV.NXT以下なら捨てる
+	for _, seq := range []uint32{peer.SendNext - 1000, peer.SendNext} {
+		oldSyn := packet.NewTCPHeader(40000, 80)
+		oldSyn.SetFlag(packet.FlagSYN)
+		oldSyn.SequenceNumber = seq
+		transmit(t, peerLink, peer, oldSyn, nil)
+		select {
+		case s := <-segments:
+			t.Errorf("Unexpected reply to SYN at %d: %s", seq, s.header)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if conn.State() != socket.StateTimeWait {
+			t.Fatalf("Expected state TIME_WAIT, got %s", conn.State())
+		}
+	}
+
+	// RCV.NXTより大きいシーケンス番号のSYNは4タプルを再利用できる
+	newSyn := packet.NewTCPHeader(40000, 80)
+	newSyn.SetFlag(packet.FlagSYN)
+	newSyn.SequenceNumber = peer.SendNext + 1000
+	transmit(t, peerLink, peer, newSyn, nil)
+	s := receive(t, segments)
+	if !s.header.HasFlag(packet.FlagSYN|packet.FlagACK) || s.header.AckNumber != newSyn.SequenceNumber+1 {
+		t.Fatalf("Expected SYN-ACK for new SYN, got %s", s.header)
+	}
+	waitForState(t, conn, socket.StateClosed)
+}
+
+func TestTimeWaitKeptWhenSynNotAccepted(t *testing.T) {
+	stack, peerLink, segments, listener := listenWithPeer(t)
+
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	conn, fin := closeToTimeWait(t, peerLink, segments, listener, peer)
+	finTS := fin.FindOption(packet.OptionKindTimestamps).(packet.TimestampsOption).Value
+
+	newSyn := packet.NewTCPHeader(40000, 80)
+	newSyn.SetFlag(packet.FlagSYN)
+	newSyn.SequenceNumber = peer.SendNext + 1000
+	newSyn.AddOption(packet.TimestampsOption{Value: finTS + 1})
+	expectDropped := func() {
+		t.Helper()
+		transmit(t, peerLink, peer, newSyn, nil)
+		select {
+		case s := <-segments:
+			t.Errorf("Unexpected reply to SYN: %s", s.header)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if conn.State() != socket.StateTimeWait {
+			t.Fatalf("Expected state TIME_WAIT, got %s", conn.State())
+		}
+	}
+
+	// 再利用できるSYNでも、受け入れるリスナーがなければTIME_WAITを保つ
+	listener.Close()
+	expectDropped()
+
+	// SYNキューが満杯でSYNを捨てる場合も同じ
+	listener, err := stack.Listen(&net.TCPAddr{IP: stackServerIP, Port: 80}, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+	other := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40001}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	syn, _ := NewThreeWayHandshake(other).StartClient()
+	transmit(t, peerLink, other, syn, nil)
+	if s := receive(t, segments); !s.header.HasFlag(packet.FlagSYN | packet.FlagACK) {
+		t.Fatalf("Expected SYN-ACK, got %s", s.header)
+	}
+	expectDropped()
+	if stats := stack.Stats(); stats.ListenDrops != 1 {
+		t.Errorf("Expected 1 listen drop, got %d", stats.ListenDrops)
+	}
+}
+
+func TestTimeWaitExpires(t *testing.T) {
+	client, server := newStackPair(t)
+	client.SetMSL(50 * time.Millisecond)
+
+	listener, err := server.Listen(&net.TCPAddr{IP: stackServerIP, Port: 80}, 0)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	dialed, err := client.Dial(context.Background(), nil, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn := dialed.(*Conn)
+	accepted := accept(t, listener)
+
+	conn.Close()
+	waitForState(t, accepted, socket.StateCloseWait)
+	accepted.Close()
+	waitForState(t, conn, socket.StateTimeWait)
+	if client.table.Len() != 1 {
+		t.Errorf("Expected TIME_WAIT connection to hold its four-tuple, %d in table", client.table.Len())
+	}
+
+	// 2*MSL経過後にCLOSEDへ移りTCBを解放する
+	start := time.Now()
+	waitForState(t, conn, socket.StateClosed)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected TIME_WAIT to last about 100ms, lasted %v", elapsed)
+	}
+	if client.table.Len() != 0 {
+		t.Errorf("Expected connection to be freed, %d left", client.table.Len())
+	}
+}
+
+// establishWithPeer completes a handshake between a raw peer TCB and a
+// listener on stack and returns the accepted connection
+func establishWithPeer(t *testing.T, peerLink link.LinkEndpoint, segments <-chan segment, listener socket.Listener, peer *TCB) *Conn {
+	t.Helper()
+	return establishWithISN(t, peerLink, segments, listener, peer, peer.GenerateISN())
+}
+
+// establishWithISN is establishWithPeer with the peer's initial sequence
+// number chosen by the caller
+func establishWithISN(t *testing.T, peerLink link.LinkEndpoint, segments <-chan segment, listener socket.Listener, peer *TCB, isn uint32) *Conn {
+	t.Helper()
+
+	handshake := NewThreeWayHandshake(peer)
+	syn, _ := handshake.StartClient()
+	syn.SequenceNumber = isn
+	peer.SendUnack = isn
+	peer.SendNext = isn + 1
+	transmit(t, peerLink, peer, syn, nil)
+	ack, err := handshake.HandleSynAck(receive(t, segments).header)
+	if err != nil {
+		t.Fatalf("HandleSynAck failed: %v", err)
+	}
+	transmit(t, peerLink, peer, ack, nil)
+	return accept(t, listener)
+}
+
+func TestResetInSynchronizedState(t *testing.T) {
+	stack, peerLink, segments, listener := listenWithPeer(t)
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	conn := establishWithPeer(t, peerLink, segments, listener, peer)
+
+	// 窓内だが期待値と一致しないRSTにはチャレンジACKを返す（RFC 5961）
+	rst := peer.newHeader(packet.FlagRST)
+	rst.SequenceNumber = peer.SendNext + 100
+	transmit(t, peerLink, peer, rst, nil)
+	s := receive(t, segments)
+	if s.header.Flags != packet.FlagACK || s.header.AckNumber != peer.SendNext {
+		t.Errorf("Expected challenge ACK of %d, got %s", peer.SendNext, s.header)
+	}
+
+	// 窓外のRSTは黙って捨てる
+	rst.SequenceNumber = peer.SendNext - 1
+	transmit(t, peerLink, peer, rst, nil)
+	select {
+	case s := <-segments:
+		t.Errorf("Unexpected reply to out-of-window RST: %s", s.header)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if conn.State() != socket.StateEstablished {
+		t.Fatalf("Expected state ESTABLISHED, got %s", conn.State())
+	}
+
+	// RCV.NXTちょうどのRSTで接続はリセットされる
+	rst.SequenceNumber = peer.SendNext
+	transmit(t, peerLink, peer, rst, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err := conn.Receive(ctx, make([]byte, 16))
+	if !errors.Is(err, ErrConnReset) || !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("Expected ErrConnReset matching ECONNRESET, got %v", err)
+	}
+	if _, err := conn.Send(context.Background(), []byte("data")); !errors.Is(err, ErrConnReset) {
+		t.Errorf("Expected ErrConnReset from Send, got %v", err)
+	}
+	if stack.table.Len() != 0 {
+		t.Errorf("Expected reset connection to be removed, %d left", stack.table.Len())
+	}
+}
+
+func TestResetInSynSent(t *testing.T) {
+	stackLink, peerLink := link.NewPipe(1500)
+	defer stackLink.Close()
+	defer peerLink.Close()
+	stack := NewStack(stackLink, stackClientIP)
+	defer stack.Close()
+	segments := attach(t, peerLink)
+
+	conn, err := stack.Connect(nil, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	syn := receive(t, segments).header
+	peer := NewTCB(&net.TCPAddr{IP: stackServerIP, Port: 80}, conn.LocalAddr())
+
+	// SYNを確認しないSYN-ACKにはそのACK番号でRSTを返す
+	synAck := peer.newHeader(packet.FlagSYN | packet.FlagACK)
+	synAck.SequenceNumber = 5000
+	synAck.AckNumber = syn.SequenceNumber + 100
+	transmit(t, peerLink, peer, synAck, nil)
+	s := receive(t, segments)
+	if s.header.Flags != packet.FlagRST || s.header.SequenceNumber != synAck.AckNumber {
+		t.Errorf("Expected RST with seq %d, got %s", synAck.AckNumber, s.header)
+	}
+
+	// ACKのないRSTは無視する
+	rst := peer.newHeader(packet.FlagRST)
+	transmit(t, peerLink, peer, rst, nil)
+	time.Sleep(20 * time.Millisecond)
+	if conn.State() != socket.StateSynSent {
+		t.Fatalf("Expected state SYN_SENT, got %s", conn.State())
+	}
+
+	// SYNを確認するRSTで接続は拒否される
+	rst = peer.newHeader(packet.FlagRST | packet.FlagACK)
+	rst.AckNumber = syn.SequenceNumber + 1
+	transmit(t, peerLink, peer, rst, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := conn.waitEstablished(ctx); !errors.Is(err, ErrConnRefused) {
+		t.Errorf("Expected ErrConnRefused, got %v", err)
+	}
+}
+
+func TestResetInSynReceived(t *testing.T) {
+	stack, peerLink, segments, listener := listenWithPeer(t)
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	syn, _ := NewThreeWayHandshake(peer).StartClient()
+	transmit(t, peerLink, peer, syn, nil)
+	synAck := receive(t, segments).header
+
+	// SYN-ACKを確認しないACKにはRSTを返す
+	ack := peer.newHeader(packet.FlagACK)
+	ack.SequenceNumber = peer.SendNext
+	ack.AckNumber = synAck.SequenceNumber + 100
+	transmit(t, peerLink, peer, ack, nil)
+	s := receive(t, segments)
+	if s.header.Flags != packet.FlagRST || s.header.SequenceNumber != ack.AckNumber {
+		t.Errorf("Expected RST with seq %d, got %s", ack.AckNumber, s.header)
+	}
+
+	// 受け付けられるRSTで半開きの接続は破棄され、リスナーは待ち受けを続ける
+	rst := peer.newHeader(packet.FlagRST)
+	rst.SequenceNumber = peer.SendNext
+	transmit(t, peerLink, peer, rst, nil)
+	deadline := time.Now().Add(2 * time.Second)
+	for stack.table.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Half-open connection was not removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	peer = NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40001}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	establishWithPeer(t, peerLink, segments, listener, peer)
+}
+
+func TestConnAbort(t *testing.T) {
+	client, server := newStackPair(t)
+
+	listener, err := server.Listen(&net.TCPAddr{IP: stackServerIP, Port: 80}, 0)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	dialed, err := client.Dial(context.Background(), nil, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn := dialed.(*Conn)
+	accepted := accept(t, listener)
+
+	// FINの代わりにRSTを送る
+	if err := conn.Abort(); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	if conn.State() != socket.StateClosed {
+		t.Errorf("Expected state CLOSED after Abort, got %s", conn.State())
+	}
+	if _, err := conn.Send(context.Background(), []byte("data")); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Expected ErrConnClosed after Abort, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := accepted.Receive(ctx, make([]byte, 16)); !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("Expected ECONNRESET on the peer, got %v", err)
+	}
+	if client.table.Len() != 0 || server.table.Len() != 0 {
+		t.Errorf("Expected connections to be removed, %d and %d left", client.table.Len(), server.table.Len())
+	}
+}
+
+func TestStackAcksUnacceptableSegments(t *testing.T) {
+	_, peerLink, segments, listener := listenWithPeer(t)
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	conn := establishWithPeer(t, peerLink, segments, listener, peer)
+
+	peerTransfer := NewDataTransfer(peer)
+	hello, _ := peerTransfer.Send([]byte("hello"))
+	transmit(t, peerLink, peer, hello, []byte("hello"))
+	if s := receive(t, segments); s.header.AckNumber != peer.SendNext {
+		t.Fatalf("Expected ACK of %d, got %s", peer.SendNext, s.header)
+	}
+
+	// 再送された重複セグメントには重複ACKを返し、データは一度だけ届ける
+	transmit(t, peerLink, peer, hello, []byte("hello"))
+	if s := receive(t, segments); s.header.AckNumber != peer.SendNext {
+		t.Errorf("Expected duplicate ACK of %d, got %s", peer.SendNext, s.header)
+	}
+
+	// ウィンドウの外のセグメントにも重複ACKを返す
+	far := peer.newHeader(packet.FlagACK)
+	far.SequenceNumber = peer.SendNext + 100000
+	far.AckNumber = peer.RecvNext
+	transmit(t, peerLink, peer, far, []byte("far"))
+	if s := receive(t, segments); s.header.AckNumber != peer.SendNext {
+		t.Errorf("Expected duplicate ACK of %d, got %s", peer.SendNext, s.header)
+	}
+
+	world, _ := peerTransfer.Send([]byte(" world"))
+	transmit(t, peerLink, peer, world, []byte(" world"))
+	if got := readN(t, conn, 11); string(got) != "hello world" {
+		t.Errorf("Expected %q, got %q", "hello world", got)
+	}
+}
+
+func TestStackIgnoresOldAcks(t *testing.T) {
+	_, peerLink, segments, listener := listenWithPeer(t)
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	conn := establishWithPeer(t, peerLink, segments, listener, peer)
+	peerTransfer := NewDataTransfer(peer)
+
+	if _, err := conn.Send(context.Background(), []byte("ping")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	s := receive(t, segments)
+	if _, _, err := peerTransfer.Receive(s.header, s.data); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	transmit(t, peerLink, peer, peer.newAck(), nil)
+
+	// 確認済みの範囲より古い重複ACKは黙って無視する
+	old := peer.newAck()
+	old.AckNumber = peer.RecvNext - 4
+	transmit(t, peerLink, peer, old, nil)
+	select {
+	case s := <-segments:
+		t.Errorf("Unexpected reply to old ACK: %s", s.header)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 古いACKを運ぶセグメントのデータは受け取る
+	hello, _ := peerTransfer.Send([]byte("hello"))
+	hello.AckNumber = peer.RecvNext - 4
+	transmit(t, peerLink, peer, hello, []byte("hello"))
+	if s := receive(t, segments); s.header.AckNumber != peer.SendNext {
+		t.Errorf("Expected ACK of %d, got %s", peer.SendNext, s.header)
+	}
+	if got := readN(t, conn, 5); string(got) != "hello" {
+		t.Errorf("Expected %q, got %q", "hello", got)
+	}
+
+	// 未送信データへのACKにはACKを返す
+	future := peer.newAck()
+	future.AckNumber = peer.RecvNext + 100
+	transmit(t, peerLink, peer, future, nil)
+	if s := receive(t, segments); s.header.AckNumber != peer.SendNext || s.header.SequenceNumber != peer.RecvNext {
+		t.Errorf("Expected ACK of %d at %d, got %s", peer.SendNext, peer.RecvNext, s.header)
+	}
+}
+
+func TestStackProcessesAcksWithZeroWindow(t *testing.T) {
+	stack, peerLink, segments, listener := listenWithPeer(t)
+	stack.SetRecvBufferSize(1000)
+
+	// 相手の受信バッファも1000バイトしかない
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	peer.RecvBufferSize = 1000
+	peer.RecvWindow = 1000
+	conn := establishWithPeer(t, peerLink, segments, listener, peer)
+	peerTransfer := NewDataTransfer(peer)
+
+	// スタックの受信バッファを埋めてゼロウィンドウにする
+	header, err := peerTransfer.Send(make([]byte, 1000))
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	transmit(t, peerLink, peer, header, make([]byte, 1000))
+	s := receive(t, segments)
+	if s.header.WindowSize != 0 {
+		t.Fatalf("Expected zero window, got %d", s.header.WindowSize)
+	}
+	if err := peerTransfer.ReceiveAck(s.header); err != nil {
+		t.Fatalf("ReceiveAck failed: %v", err)
+	}
+
+	// スタックからの送信は相手のウィンドウで止まる
+	if _, err := conn.Send(context.Background(), make([]byte, 2000)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	s = receive(t, segments)
+	if _, _, err := peerTransfer.Receive(s.header, s.data); err != nil || len(s.data) != 1000 {
+		t.Fatalf("Expected 1000 bytes, got %d (%v)", len(s.data), err)
+	}
+
+	// ゼロウィンドウで破棄されるデータに載ったACKとウィンドウの更新は処理される
+	peerTransfer.Read(make([]byte, 1000))
+	peer.updateRecvWindow()
+	data := peer.newAck()
+	data.SetFlag(packet.FlagPSH)
+	transmit(t, peerLink, peer, data, []byte("x"))
+
+	var sent, acked bool
+	for !sent || !acked {
+		s := receive(t, segments)
+		switch {
+		case len(s.data) == 1000 && s.header.SequenceNumber == peer.RecvNext:
+			sent = true
+		case len(s.data) == 0 && s.header.AckNumber == peer.SendNext && s.header.WindowSize == 0:
+			acked = true
+		default:
+			t.Fatalf("Unexpected segment: %s with %d bytes", s.header, len(s.data))
+		}
+	}
+}
+
+func TestStackReassemblesOutOfOrderSegments(t *testing.T) {
+	_, peerLink, segments, listener := listenWithPeer(t)
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	conn := establishWithPeer(t, peerLink, segments, listener, peer)
+
+	peerTransfer := NewDataTransfer(peer)
+	hello, _ := peerTransfer.Send([]byte("hello"))
+	world, _ := peerTransfer.Send([]byte(" world"))
+
+	// 先のセグメントが失われると、後続のセグメントには重複ACKが返る
+	transmit(t, peerLink, peer, world, []byte(" world"))
+	if s := receive(t, segments); s.header.AckNumber != hello.SequenceNumber {
+		t.Fatalf("Expected duplicate ACK of %d, got %s", hello.SequenceNumber, s.header)
+	}
+
+	// 失われたセグメントだけを再送すれば、保持していたデータと合わせて確認される
+	transmit(t, peerLink, peer, hello, []byte("hello"))
+	if s := receive(t, segments); s.header.AckNumber != peer.SendNext {
+		t.Errorf("Expected ACK of %d, got %s", peer.SendNext, s.header)
+	}
+	if got := readN(t, conn, 11); string(got) != "hello world" {
+		t.Errorf("Expected %q, got %q", "hello world", got)
+	}
+}
+
+func TestStackHonorsPeerWindow(t *testing.T) {
+	_, peerLink, segments, listener := listenWithPeer(t)
+
+	// 相手の受信バッファは1000バイトしかない
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	peer.RecvBufferSize = 1000
+	peer.RecvWindow = 1000
+	conn := establishWithPeer(t, peerLink, segments, listener, peer)
+	peerTransfer := NewDataTransfer(peer)
+
+	message := make([]byte, 3000)
+	for i := range message {
+		message[i] = byte(i)
+	}
+	if n, err := conn.Send(context.Background(), message); err != nil || n != len(message) {
+		t.Fatalf("Send failed: n=%d err=%v", n, err)
+	}
+	// FINは送信待ちのデータをすべて送った後に送られる
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var received []byte
+	for len(received) < len(message) {
+		s := receive(t, segments)
+		if len(s.data) != 1000 {
+			t.Fatalf("Expected a segment filling the 1000 byte window, got %d bytes", len(s.data))
+		}
+		data, ack, err := peerTransfer.Receive(s.header, s.data)
+		if err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+		received = append(received, data...)
+
+		// ゼロウィンドウの間は何も送られない
+		if ack.WindowSize != 0 {
+			t.Fatalf("Expected zero window, got %d", ack.WindowSize)
+		}
+		transmit(t, peerLink, peer, ack, nil)
+		if len(received) == len(message) {
+			break
+		}
+		select {
+		case s := <-segments:
+			t.Fatalf("Unexpected segment while the window is closed: %s", s.header)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		// 相手が読み出してウィンドウを開くと続きが送られる
+		peerTransfer.Read(make([]byte, 1000))
+		if !peer.updateRecvWindow() {
+			t.Fatal("Expected peer window to open")
+		}
+		transmit(t, peerLink, peer, peer.newAck(), nil)
+	}
+	if string(received) != string(message) {
+		t.Error("Received data does not match")
+	}
+	if s := receive(t, segments); !s.header.HasFlag(packet.FlagFIN) || s.header.SequenceNumber != peer.RecvNext {
+		t.Errorf("Expected FIN at %d after the data, got %s", peer.RecvNext, s.header)
+	}
+}
+
+func TestStackAdvertisesFreeBufferSpace(t *testing.T) {
+	stack, peerLink, segments, listener := listenWithPeer(t)
+	stack.SetRecvBufferSize(4000)
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	conn := establishWithPeer(t, peerLink, segments, listener, peer)
+	if peer.SendWindow != 4000 {
+		t.Fatalf("Expected SYN-ACK to advertise 4000, got %d", peer.SendWindow)
+	}
+
+	// 読み出されないデータの分だけウィンドウが縮む
+	peerTransfer := NewDataTransfer(peer)
+	for expected := 3000; expected >= 0; expected -= 1000 {
+		header, err := peerTransfer.Send(make([]byte, 1000))
+		if err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		transmit(t, peerLink, peer, header, make([]byte, 1000))
+		s := receive(t, segments)
+		if int(s.header.WindowSize) != expected {
+			t.Errorf("Expected window %d, got %d", expected, s.header.WindowSize)
+		}
+		if err := peerTransfer.ReceiveAck(s.header); err != nil {
+			t.Fatalf("ReceiveAck failed: %v", err)
+		}
+	}
+	if _, err := peerTransfer.Send([]byte("x")); err == nil {
+		t.Error("Expected error when sending into a zero window")
+	}
+
+	// 少しだけ読んでも小さなウィンドウは広告しない（SWS回避）
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := conn.Receive(ctx, make([]byte, 100)); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	select {
+	case s := <-segments:
+		t.Errorf("Unexpected window update after a small read: %s", s.header)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// MSS以上の空きができたらウィンドウの更新を送る
+	readN(t, conn, 2000)
+	s := receive(t, segments)
+	if s.header.WindowSize != 2100 || s.header.AckNumber != peer.SendNext {
+		t.Errorf("Expected window update of 2100 at %d, got %s", peer.SendNext, s.header)
+	}
+}
+
+func TestStackPersistTimer(t *testing.T) {
+	stack, peerLink, segments, listener := listenWithPeer(t)
+	stack.SetRetransmissionTimeout(20 * time.Millisecond)
+	stack.SetRetransmissionTimeoutBounds(20*time.Millisecond, time.Second)
+	stack.SetSendBufferSize(2000)
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	peer.RecvBufferSize = 1000
+	peer.RecvWindow = 1000
+	conn := establishWithPeer(t, peerLink, segments, listener, peer)
+	peerTransfer := NewDataTransfer(peer)
+
+	message := make([]byte, 4000)
+	for i := range message {
+		message[i] = byte(i)
+	}
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := conn.Send(context.Background(), message)
+		done <- result{n, err}
+	}()
+
+	// 相手は最初の1000バイトを受け取った後、読み出しを止める
+	s := receive(t, segments)
+	received, ack, err := peerTransfer.Receive(s.header, s.data)
+	if err != nil || len(received) != 1000 || ack.WindowSize != 0 {
+		t.Fatalf("Expected 1000 bytes and a zero window, got %d bytes, window %d (%v)", len(received), ack.WindowSize, err)
+	}
+	transmit(t, peerLink, peer, ack, nil)
+
+	// ゼロウィンドウの間は1バイトのプローブが間隔を広げながら送られる。
+	// 相手がACKを返す限り、最大再送回数を超えても接続は切れない。
+	var sentAt []time.Time
+	for range 4 {
+		s := receive(t, segments)
+		sentAt = append(sentAt, time.Now())
+		if len(s.data) != 1 || s.header.SequenceNumber != peer.RecvNext {
+			t.Fatalf("Expected 1 byte probe at %d, got %s with %d bytes", peer.RecvNext, s.header, len(s.data))
+		}
+		_, ack, err := peerTransfer.Receive(s.header, s.data)
+		if err != nil || ack.WindowSize != 0 {
+			t.Fatalf("Expected the probe to be refused with a zero window, got window %d (%v)", ack.WindowSize, err)
+		}
+		transmit(t, peerLink, peer, ack, nil)
+	}
+	for i := 2; i < len(sentAt); i++ {
+		if sentAt[i].Sub(sentAt[i-1]) <= sentAt[i-1].Sub(sentAt[i-2]) {
+			t.Errorf("Expected probe intervals to grow, got %v", sentAt)
+		}
+	}
+	select {
+	case r := <-done:
+		t.Fatalf("Expected Send to block while the window is closed, got n=%d err=%v", r.n, r.err)
+	default:
+	}
+
+	// 相手が読み出してウィンドウを開くと送信が再開し、待っていたSendも戻る
+	for len(received) < len(message) {
+		peerTransfer.Read(make([]byte, len(peer.RecvBuffer)))
+		peer.updateRecvWindow()
+		transmit(t, peerLink, peer, peer.newAck(), nil)
+
+		s := receive(t, segments)
+		data, _, err := peerTransfer.Receive(s.header, s.data)
+		if err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+		received = append(received, data...)
+	}
+	if string(received) != string(message) {
+		t.Error("Received data does not match")
+	}
+	transmit(t, peerLink, peer, peer.newAck(), nil)
+	select {
+	case r := <-done:
+		if r.err != nil || r.n != len(message) {
+			t.Errorf("Send failed: n=%d err=%v", r.n, r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Send did not return after the window opened")
+	}
+	if conn.State() != socket.StateEstablished {
+		t.Errorf("Expected connection to stay established, got %s", conn.State())
+	}
+}
+
+func TestStackRetransmissionRefreshesTimestamps(t *testing.T) {
+	stack, peerLink, segments, listener := listenWithPeer(t)
+	stack.SetRetransmissionTimeout(50 * time.Millisecond)
+	stack.SetRetransmissionTimeoutBounds(50*time.Millisecond, time.Second)
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	conn := establishWithPeer(t, peerLink, segments, listener, peer)
+
+	if _, err := conn.Send(context.Background(), []byte("hello")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	first := receive(t, segments)
+	firstTS, ok := first.header.FindOption(packet.OptionKindTimestamps).(packet.TimestampsOption)
+	if !ok {
+		t.Fatalf("Expected timestamps option, got %s", first.header)
+	}
+
+	// データを確認応答しないまま新しいTSvalを送り、再送で返されるかを見る
+	ack := peer.newAck()
+	peerTS := firstTS.EchoReply + 1000
+	for i, opt := range ack.Options {
+		if _, ok := opt.(packet.TimestampsOption); ok {
+			ack.Options[i] = packet.TimestampsOption{Value: peerTS, EchoReply: firstTS.Value}
+		}
+	}
+	transmit(t, peerLink, peer, ack, nil)
+
+	retransmitted := receive(t, segments)
+	if retransmitted.header.SequenceNumber != first.header.SequenceNumber || string(retransmitted.data) != "hello" {
+		t.Fatalf("Expected retransmission of the data, got %s", retransmitted.header)
+	}
+	ts, ok := retransmitted.header.FindOption(packet.OptionKindTimestamps).(packet.TimestampsOption)
+	if !ok {
+		t.Fatalf("Expected timestamps option on retransmission, got %s", retransmitted.header)
+	}
+	if ts.Value <= firstTS.Value {
+		t.Errorf("Expected TSval newer than %d, got %d", firstTS.Value, ts.Value)
+	}
+	if ts.EchoReply != peerTS {
+		t.Errorf("Expected TSecr %d, got %d", peerTS, ts.EchoReply)
+	}
+}
+
+func TestConnRTTStats(t *testing.T) {
+	client, server := newStackPair(t)
+	client.SetRetransmissionTimeoutBounds(300*time.Millisecond, 5*time.Second)
+
+	listener, err := server.Listen(&net.TCPAddr{IP: stackServerIP, Port: 80}, 0)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	dialed, err := client.Dial(context.Background(), nil, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn := dialed.(*Conn)
+	accepted := accept(t, listener)
+
+	// 統計は転送中でもロックを取って読める
+	type stats struct{ srtt, rttvar, rto time.Duration }
+	stop := make(chan struct{})
+	polled := make(chan stats)
+	go func() {
+		var last stats
+		for {
+			last.srtt, last.rttvar, last.rto = conn.RTTStats()
+			select {
+			case <-stop:
+				polled <- last
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+
+	message := make([]byte, 20000)
+	if _, err := conn.Send(context.Background(), message); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	readN(t, accepted, len(message))
+	close(stop)
+	last := <-polled
+
+	if last.srtt <= 0 || last.rttvar <= 0 {
+		t.Errorf("Expected RTT samples, got SRTT %v and RTTVAR %v", last.srtt, last.rttvar)
+	}
+	if last.rto < 300*time.Millisecond || last.rto > 5*time.Second {
+		t.Errorf("Expected RTO within the configured bounds, got %v", last.rto)
+	}
+}
+
+func TestStackWindowAbove64KiB(t *testing.T) {
+	stack, peerLink, segments, listener := listenWithPeer(t)
+	stack.SetRecvBufferSize(1 << 20)
+	peer := NewTCB(&net.TCPAddr{IP: stackClientIP, Port: 40000}, &net.TCPAddr{IP: stackServerIP, Port: 80})
+	conn := establishWithPeer(t, peerLink, segments, listener, peer)
+	if peer.SendWindowShift != 5 {
+		t.Fatalf("Expected the stack to advertise shift 5, got %d", peer.SendWindowShift)
+	}
+
+	// SYN-ACKの後の最初のACKでスケールしたウィンドウが分かる
+	peerTransfer := NewDataTransfer(peer)
+	header, _ := peerTransfer.Send([]byte("hello"))
+	transmit(t, peerLink, peer, header, []byte("hello"))
+	if err := peerTransfer.ReceiveAck(receive(t, segments).header); err != nil {
+		t.Fatalf("ReceiveAck failed: %v", err)
+	}
+	if peer.SendWindow <= 65535 {
+		t.Fatalf("Expected a window above 64KiB, got %d", peer.SendWindow)
+	}
+
+	// 64KiBを超えるデータをACKを待たずに送れる（ACKは読み捨てる）
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-segments:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	message := make([]byte, 100*1000)
+	for i := range message {
+		message[i] = byte(i)
+	}
+	for sent := 0; sent < len(message); sent += 1000 {
+		chunk := message[sent : sent+1000]
+		header, err := peerTransfer.Send(chunk)
+		if err != nil {
+			t.Fatalf("Send failed at offset %d: %v", sent, err)
+		}
+		transmit(t, peerLink, peer, header, chunk)
+	}
+	readN(t, conn, len("hello"))
+	if got := readN(t, conn, len(message)); string(got) != string(message) {
+		t.Error("Received data does not match")
+	}
+}
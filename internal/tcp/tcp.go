@@ -19,6 +19,8 @@ type RetransmissionEntry struct {
 	Data     []byte
 	SentTime time.Time
 	Attempts int
+
+	restarted bool // Restartで送り直した（RTTの計測に使わない）
 }
 
 // RetransmissionQueue manages packets that need potential retransmission
@@ -48,12 +50,13 @@ func (rq *RetransmissionQueue) Add(header *packet.TCPHeader, data []byte) {
 	rq.entries = append(rq.entries, entry)
 }
 
-// Remove removes acknowledged packets from the queue
-func (rq *RetransmissionQueue) Remove(ackNumber uint32) {
+// Remove removes acknowledged packets from the queue and returns them
+func (rq *RetransmissionQueue) Remove(ackNumber uint32) []RetransmissionEntry {
 	rq.mutex.Lock()
 	defer rq.mutex.Unlock()
 
 	// Remove entries that have been acknowledged
+	var acked []RetransmissionEntry
 	newEntries := make([]RetransmissionEntry, 0)
 	for _, entry := range rq.entries {
 		// If the ACK number is greater than the sequence number + data length,
@@ -63,11 +66,14 @@ func (rq *RetransmissionQueue) Remove(ackNumber uint32) {
 			seqEnd++ // SYN and FIN consume one sequence number
 		}
 
-		if ackNumber < seqEnd {
+		if SeqLT(ackNumber, seqEnd) {
 			newEntries = append(newEntries, entry)
+		} else {
+			acked = append(acked, entry)
 		}
 	}
 	rq.entries = newEntries
+	return acked
 }
 
 // GetTimeoutEntries returns entries that have timed out and need retransmission
@@ -92,6 +98,35 @@ func (rq *RetransmissionQueue) GetTimeoutEntries(timeout time.Duration, maxAttem
 	return timeoutEntries
 }
 
+// Restart returns the oldest entry for an immediate resend and restarts its
+// timer without counting an attempt
+func (rq *RetransmissionQueue) Restart() (RetransmissionEntry, bool) {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+
+	if len(rq.entries) == 0 {
+		return RetransmissionEntry{}, false
+	}
+	rq.entries[0].SentTime = time.Now()
+	rq.entries[0].restarted = true
+	return rq.entries[0], true
+}
+
+// HasExpired returns true if an entry has used up its retransmission
+// attempts and timed out once more, i.e. the peer is unreachable
+func (rq *RetransmissionQueue) HasExpired(timeout time.Duration, maxAttempts int) bool {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+
+	now := time.Now()
+	for _, entry := range rq.entries {
+		if entry.Attempts >= maxAttempts && now.Sub(entry.SentTime) > timeout {
+			return true
+		}
+	}
+	return false
+}
+
 // Size returns the number of entries in the queue
 func (rq *RetransmissionQueue) Size() int {
 	rq.mutex.Lock()
@@ -99,6 +134,32 @@ func (rq *RetransmissionQueue) Size() int {
 	return len(rq.entries)
 }
 
+// Default maximum segment sizes
+const (
+	DefaultMSS     = 1460 // 1500 byte Ethernet MTU - 40 bytes of IPv4/TCP headers
+	DefaultSendMSS = 536  // Assumed when the peer sends no MSS option (RFC 1122)
+)
+
+// Default buffer sizes
+const (
+	DefaultSendBufferSize = 64 * 1024 // 未確認と未送信のデータの上限
+	DefaultRecvBufferSize = 64 * 1024 // アプリケーションが読んでいないデータの上限
+)
+
+// Default retransmission settings
+const (
+	DefaultRetransmissionTimeout     = 1 * time.Second // デフォルト1秒（RTTを計測するまでの初期値）
+	DefaultMaxRetransmissionAttempts = 3               // 最大3回再送
+
+	// 計測したRTTから求めたRTOの範囲。RFC 6298は下限1秒を推奨するが、
+	// 一般的な実装に合わせて200msとする
+	DefaultMinRetransmissionTimeout = 200 * time.Millisecond
+	DefaultMaxRetransmissionTimeout = 60 * time.Second
+)
+
+// MaxPersistTimeout caps the exponential backoff of zero window probes
+const MaxPersistTimeout = 60 * time.Second
+
 // TCB (Transmission Control Block) represents the state of a TCP connection
 type TCB struct {
 	// Connection identification
@@ -109,19 +170,54 @@ type TCB struct {
 	SendNext   uint32 // 次に送信するシーケンス番号
 	SendUnack  uint32 // 未確認の最古のシーケンス番号
 	RecvNext   uint32 // 次に受信を期待するシーケンス番号
-	RecvWindow uint16 // 受信ウィンドウサイズ
+	RecvWindow uint32 // 受信ウィンドウ（RCV.WND、バイト単位。右端はRecvNext+RecvWindow）
+
+	// Send window advertised by the peer
+	SendWindow uint32 // SND.WND（バイト単位）
+	SendWL1    uint32 // 最後にウィンドウを更新したセグメントのシーケンス番号
+	SendWL2    uint32 // 最後にウィンドウを更新したセグメントのACK番号
+
+	prevSendNext *uint32 // 同じ4タプルの前の接続のSND.NXT（TIME_WAITからの再利用時）
 
 	// State
 	State socket.SocketState
 
 	// Buffers
-	SendBuffer []byte
-	RecvBuffer []byte
+	SendBuffer     []byte // 送信済みで未確認のデータ（SendUnackから）
+	Unsent         []byte // 送信ウィンドウが開くのを待つデータ
+	SendBufferSize int    // SendBufferとUnsentの合計の上限
+	RecvBuffer     []byte
+	RecvBufferSize int // RecvBufferの上限（空き容量を受信ウィンドウとして広告する）
+
+	// Out-of-order data beyond RecvNext
+	ReassemblyQueue *ReassemblyQueue
 
 	// Retransmission management
 	RetransmissionQueue       *RetransmissionQueue
-	RetransmissionTimeout     time.Duration
+	RetransmissionTimeout     time.Duration // 現在のRTO（RTTの計測とバックオフで変わる）
+	MinRetransmissionTimeout  time.Duration // 計測から求めたRTOの下限
+	MaxRetransmissionTimeout  time.Duration // RTOの上限
 	MaxRetransmissionAttempts int
+
+	// Round-trip time estimation (RFC 6298)
+	SRTT   time.Duration // 平滑化したRTT（計測前はゼロ）
+	RTTVAR time.Duration // RTTのばらつき
+
+	// Negotiated options
+	MSS             uint16 // 自分が受信可能な最大セグメントサイズ（SYNで広告）
+	SendMSS         uint16 // 相手に送信する最大セグメントサイズ
+	WindowScaleOK   bool   // 双方がWSCALEを広告したか
+	RecvWindowShift uint8  // 自分が広告したウィンドウスケール
+	SendWindowShift uint8  // 相手が広告したウィンドウスケール
+	SACKPermitted   bool   // 双方がSACK_PERMITTEDを広告したか
+	TimestampsOK    bool   // 双方がタイムスタンプを広告したか
+	TSRecent        uint32 // 相手から最後に受信したTSval
+
+	// Checksum validation of incoming segments. A Stack verifies every
+	// segment itself and leaves VerifyChecksum off; ChecksumErrors counts the
+	// corrupted segments addressed to this TCB in either case.
+	VerifyChecksum bool   // ハンドラで受信セグメントのチェックサムを検証するか
+	ChecksumErrors uint64 // チェックサム不一致で破棄したセグメント数
 }
 
 // NewTCB creates a new TCP Control Block
@@ -130,10 +226,18 @@ func NewTCB(localAddr, remoteAddr *net.TCPAddr) *TCB {
 		LocalAddr:                 localAddr,
 		RemoteAddr:                remoteAddr,
 		State:                     socket.StateClosed,
-		RecvWindow:                65535, // デフォルトウィンドウサイズ
+		RecvWindow:                DefaultRecvBufferSize, // 受信バッファが空なので全体を広告する
+		RecvWindowShift:           windowShift(DefaultRecvBufferSize),
+		SendBufferSize:            DefaultSendBufferSize,
+		RecvBufferSize:            DefaultRecvBufferSize,
+		MSS:                       DefaultMSS,
+		SendMSS:                   DefaultSendMSS,
+		ReassemblyQueue:           NewReassemblyQueue(),
 		RetransmissionQueue:       NewRetransmissionQueue(),
-		RetransmissionTimeout:     1 * time.Second, // デフォルト1秒
-		MaxRetransmissionAttempts: 3,               // 最大3回再送
+		RetransmissionTimeout:     DefaultRetransmissionTimeout,
+		MinRetransmissionTimeout:  DefaultMinRetransmissionTimeout,
+		MaxRetransmissionTimeout:  DefaultMaxRetransmissionTimeout,
+		MaxRetransmissionAttempts: DefaultMaxRetransmissionAttempts,
 	}
 }
 
@@ -142,9 +246,131 @@ func (tcb *TCB) GenerateISN() uint32 {
 	// 簡易的なISN生成（実際にはより複雑なアルゴリズムを使用）
 	var isn [4]byte
 	rand.Read(isn[:])
+	if tcb.prevSendNext != nil {
+		// 前の接続の古いセグメントと混ざらないよう、そのシーケンス空間の後ろから始める（RFC 1122 4.2.2.13）
+		return *tcb.prevSendNext + 1 + binary.BigEndian.Uint32(isn[:])>>8
+	}
 	return binary.BigEndian.Uint32(isn[:])
 }
 
+// checkSegment verifies the checksum of an incoming segment if
+// VerifyChecksum is set and records the peer's timestamp for echoing.
+// Corrupted segments are counted and must be dropped by the caller.
+func (tcb *TCB) checkSegment(header *packet.TCPHeader, data []byte) error {
+	// 待ち受け中のTCBは相手のアドレスを知らず擬似ヘッダーを作れないので検証できない
+	if tcb.VerifyChecksum && tcb.LocalAddr != nil && tcb.RemoteAddr != nil {
+		if err := header.VerifyChecksum(tcb.RemoteAddr.IP, tcb.LocalAddr.IP, data); err != nil {
+			return tcb.checksumError(err)
+		}
+	}
+	tcb.recordTimestamp(header)
+	return nil
+}
+
+// recordTimestamp keeps the peer's TSval to echo in TSecr
+func (tcb *TCB) recordTimestamp(header *packet.TCPHeader) {
+	// 簡易版: RFC 7323 の PAWS 判定は行わず最新の TSval を保持する
+	if tcb.TimestampsOK {
+		if ts, ok := header.FindOption(packet.OptionKindTimestamps).(packet.TimestampsOption); ok {
+			tcb.TSRecent = ts.Value
+		}
+	}
+}
+
+// checksumError counts a corrupted segment and returns the error its
+// handler reports
+func (tcb *TCB) checksumError(err error) error {
+	tcb.ChecksumErrors++
+	return fmt.Errorf("dropping corrupted segment: %w", err)
+}
+
+// newHeader creates a header for this connection with the given flags,
+// the current receive window and, once negotiated, the timestamps option
+func (tcb *TCB) newHeader(flags uint8) *packet.TCPHeader {
+	header := packet.NewTCPHeader(
+		uint16(tcb.LocalAddr.Port),
+		uint16(tcb.RemoteAddr.Port),
+	)
+	header.SetFlag(flags)
+	header.WindowSize = tcb.windowField(flags&packet.FlagSYN != 0)
+
+	if tcb.TimestampsOK {
+		header.AddOption(packet.NOPOption{})
+		header.AddOption(packet.NOPOption{})
+		header.AddOption(packet.TimestampsOption{Value: tsNow(), EchoReply: tcb.TSRecent})
+	}
+	return header
+}
+
+// refreshHeader updates a queued segment before it is sent again so that
+// it carries the current acknowledgment, window and timestamps
+func (tcb *TCB) refreshHeader(header *packet.TCPHeader) {
+	acking := header.HasFlag(packet.FlagACK)
+	if acking {
+		header.AckNumber = tcb.RecvNext
+		header.WindowSize = tcb.windowField(header.HasFlag(packet.FlagSYN))
+	}
+	for i, opt := range header.Options {
+		if ts, ok := opt.(packet.TimestampsOption); ok {
+			// 古いTSvalのままだと相手のRTT計測やPAWS判定を狂わせる
+			ts.Value = tsNow()
+			if acking {
+				ts.EchoReply = tcb.TSRecent
+			}
+			header.Options[i] = ts
+		}
+	}
+}
+
+// addSynOptions adds the options carried only on SYN segments.
+// The active opener advertises everything it supports; the passive side
+// only answers with what the peer's SYN offered.
+func (tcb *TCB) addSynOptions(header *packet.TCPHeader, active bool) {
+	header.AddOption(packet.MSSOption{MSS: tcb.MSS})
+	if active || tcb.SACKPermitted {
+		header.AddOption(packet.SACKPermittedOption{})
+	}
+	if active {
+		header.AddOption(packet.NOPOption{})
+		header.AddOption(packet.NOPOption{})
+		header.AddOption(packet.TimestampsOption{Value: tsNow()})
+	}
+	if active || tcb.WindowScaleOK {
+		header.AddOption(packet.NOPOption{})
+		header.AddOption(packet.WindowScaleOption{Shift: tcb.RecvWindowShift})
+	}
+}
+
+// negotiateOptions records the options from the peer's SYN or SYN-ACK
+func (tcb *TCB) negotiateOptions(header *packet.TCPHeader) {
+	tcb.SendMSS = DefaultSendMSS
+	if mss, ok := header.FindOption(packet.OptionKindMSS).(packet.MSSOption); ok {
+		tcb.SendMSS = min(mss.MSS, tcb.MSS)
+	}
+
+	ws, ok := header.FindOption(packet.OptionKindWindowScale).(packet.WindowScaleOption)
+	tcb.WindowScaleOK = ok
+	if ok {
+		tcb.SendWindowShift = min(ws.Shift, 14) // RFC 7323: シフト量は最大14
+	} else {
+		tcb.SendWindowShift = 0
+		tcb.RecvWindowShift = 0
+	}
+
+	tcb.SACKPermitted = header.FindOption(packet.OptionKindSACKPermitted) != nil
+
+	ts, ok := header.FindOption(packet.OptionKindTimestamps).(packet.TimestampsOption)
+	tcb.TimestampsOK = ok
+	if ok {
+		tcb.TSRecent = ts.Value
+	}
+}
+
+// tsNow returns the current value of the timestamp clock (milliseconds)
+func tsNow() uint32 {
+	return uint32(time.Now().UnixMilli())
+}
+
 // ThreeWayHandshake handles the TCP three-way handshake process
 type ThreeWayHandshake struct {
 	tcb *TCB
@@ -166,13 +392,9 @@ func (h *ThreeWayHandshake) StartClient() (*packet.TCPHeader, error) {
 	h.tcb.SendNext = isn + 1
 	h.tcb.SendUnack = isn
 
-	synHeader := packet.NewTCPHeader(
-		uint16(h.tcb.LocalAddr.Port),
-		uint16(h.tcb.RemoteAddr.Port),
-	)
+	synHeader := h.tcb.newHeader(packet.FlagSYN)
 	synHeader.SequenceNumber = isn
-	synHeader.SetFlag(packet.FlagSYN)
-	synHeader.WindowSize = h.tcb.RecvWindow
+	h.tcb.addSynOptions(synHeader, true)
 
 	// Add SYN packet to retransmission queue
 	h.tcb.RetransmissionQueue.Add(synHeader, nil)
@@ -189,22 +411,27 @@ func (h *ThreeWayHandshake) HandleSyn(synHeader *packet.TCPHeader) (*packet.TCPH
 		return nil, fmt.Errorf("connection must be in LISTEN state to handle SYN")
 	}
 
-	// Store client's sequence number
+	if err := h.tcb.checkSegment(synHeader, nil); err != nil {
+		return nil, err
+	}
+
+	// Store client's sequence number and negotiate options
 	h.tcb.RecvNext = synHeader.SequenceNumber + 1
+	h.tcb.negotiateOptions(synHeader)
+	h.tcb.resetSendWindow(synHeader)
 
 	// Generate our ISN and create SYN-ACK packet
 	isn := h.tcb.GenerateISN()
 	h.tcb.SendNext = isn + 1
 	h.tcb.SendUnack = isn
 
-	synAckHeader := packet.NewTCPHeader(
-		uint16(h.tcb.LocalAddr.Port),
-		uint16(h.tcb.RemoteAddr.Port),
-	)
+	synAckHeader := h.tcb.newHeader(packet.FlagSYN | packet.FlagACK)
 	synAckHeader.SequenceNumber = isn
 	synAckHeader.AckNumber = h.tcb.RecvNext
-	synAckHeader.SetFlag(packet.FlagSYN | packet.FlagACK)
-	synAckHeader.WindowSize = h.tcb.RecvWindow
+	h.tcb.addSynOptions(synAckHeader, false)
+
+	// Add SYN-ACK packet to retransmission queue
+	h.tcb.RetransmissionQueue.Add(synAckHeader, nil)
 
 	// Transition to SYN_RECEIVED state
 	h.tcb.State = socket.StateSynReceived
@@ -218,26 +445,28 @@ func (h *ThreeWayHandshake) HandleSynAck(synAckHeader *packet.TCPHeader) (*packe
 		return nil, fmt.Errorf("connection must be in SYN_SENT state to handle SYN-ACK")
 	}
 
+	if err := h.tcb.checkSegment(synAckHeader, nil); err != nil {
+		return nil, err
+	}
+
 	// Verify ACK number
 	if synAckHeader.AckNumber != h.tcb.SendNext {
 		return nil, fmt.Errorf("invalid ACK number in SYN-ACK")
 	}
 
-	// Store server's sequence number
+	// Store server's sequence number and the options it agreed to
 	h.tcb.RecvNext = synAckHeader.SequenceNumber + 1
+	h.tcb.negotiateOptions(synAckHeader)
+	h.tcb.resetSendWindow(synAckHeader)
 
 	// Create ACK packet
-	ackHeader := packet.NewTCPHeader(
-		uint16(h.tcb.LocalAddr.Port),
-		uint16(h.tcb.RemoteAddr.Port),
-	)
+	ackHeader := h.tcb.newHeader(packet.FlagACK)
 	ackHeader.SequenceNumber = h.tcb.SendNext
 	ackHeader.AckNumber = h.tcb.RecvNext
-	ackHeader.SetFlag(packet.FlagACK)
-	ackHeader.WindowSize = h.tcb.RecvWindow
 
 	// Remove SYN from retransmission queue (it's been acknowledged by SYN-ACK)
-	h.tcb.RetransmissionQueue.Remove(synAckHeader.AckNumber)
+	h.tcb.SendUnack = synAckHeader.AckNumber
+	h.tcb.acknowledge(synAckHeader.AckNumber)
 
 	// Connection established
 	h.tcb.State = socket.StateEstablished
@@ -251,6 +480,10 @@ func (h *ThreeWayHandshake) HandleAck(ackHeader *packet.TCPHeader) error {
 		return fmt.Errorf("connection must be in SYN_RECEIVED state to handle final ACK")
 	}
 
+	if err := h.tcb.checkSegment(ackHeader, nil); err != nil {
+		return err
+	}
+
 	// Verify ACK number
 	if ackHeader.AckNumber != h.tcb.SendNext {
 		return fmt.Errorf("invalid ACK number in final ACK")
@@ -262,7 +495,9 @@ func (h *ThreeWayHandshake) HandleAck(ackHeader *packet.TCPHeader) error {
 	}
 
 	// Remove SYN-ACK from retransmission queue
-	h.tcb.RetransmissionQueue.Remove(ackHeader.AckNumber)
+	h.tcb.SendUnack = ackHeader.AckNumber
+	h.tcb.acknowledge(ackHeader.AckNumber)
+	h.tcb.resetSendWindow(ackHeader)
 
 	// Connection established
 	h.tcb.State = socket.StateEstablished
@@ -275,6 +510,158 @@ func (tcb *TCB) GetState() socket.SocketState {
 	return tcb.State
 }
 
+// newAck creates an ACK for everything received so far
+func (tcb *TCB) newAck() *packet.TCPHeader {
+	ack := tcb.newHeader(packet.FlagACK)
+	ack.SequenceNumber = tcb.SendNext
+	ack.AckNumber = tcb.RecvNext
+	return ack
+}
+
+// windowShift returns the smallest window scale that lets a receive buffer
+// of size bytes be advertised in full (RFC 7323 allows at most 14)
+func windowShift(size int) uint8 {
+	var shift uint8
+	for shift < 14 && size>>shift > 0xFFFF {
+		shift++
+	}
+	return shift
+}
+
+// windowField encodes the receive window for the window field of a
+// segment. It is scaled down only once both sides agreed on window
+// scaling, and never in a SYN (RFC 7323 2.2).
+func (tcb *TCB) windowField(syn bool) uint16 {
+	wnd := tcb.RecvWindow
+	if tcb.WindowScaleOK && !syn {
+		wnd >>= tcb.RecvWindowShift
+	}
+	return uint16(min(wnd, 0xFFFF))
+}
+
+// peerWindow decodes the window field of a segment from the peer in bytes
+func (tcb *TCB) peerWindow(header *packet.TCPHeader) uint32 {
+	wnd := uint32(header.WindowSize)
+	if tcb.WindowScaleOK && !header.HasFlag(packet.FlagSYN) {
+		wnd <<= tcb.SendWindowShift
+	}
+	return wnd
+}
+
+// updateRecvWindow reopens the receive window after the application has
+// read from RecvBuffer and reports whether it did. The right edge only
+// advances once it can move by at least min(RCV.BUFF/2, MSS), so a slow
+// reader does not advertise tiny windows (receiver-side silly window
+// syndrome avoidance, RFC 1122 4.2.3.3).
+func (tcb *TCB) updateRecvWindow() bool {
+	free := uint32(max(tcb.RecvBufferSize-len(tcb.RecvBuffer), 0))
+	if free <= tcb.RecvWindow || free-tcb.RecvWindow < min(uint32(tcb.RecvBufferSize)/2, uint32(tcb.MSS)) {
+		return false
+	}
+	tcb.RecvWindow = free
+	return true
+}
+
+// resetSendWindow takes SND.WND from a handshake segment unconditionally
+func (tcb *TCB) resetSendWindow(header *packet.TCPHeader) {
+	tcb.SendWindow = tcb.peerWindow(header)
+	tcb.SendWL1 = header.SequenceNumber
+	tcb.SendWL2 = header.AckNumber
+}
+
+// updateSendWindow takes SND.WND from an acceptable ACK unless the segment
+// is older than the one that last updated it (RFC 793 3.9)
+func (tcb *TCB) updateSendWindow(header *packet.TCPHeader) {
+	seq, ack := header.SequenceNumber, header.AckNumber
+	if SeqLT(ack, tcb.SendUnack) || SeqGT(ack, tcb.SendNext) {
+		return
+	}
+	if SeqLT(tcb.SendWL1, seq) || (tcb.SendWL1 == seq && SeqLEQ(tcb.SendWL2, ack)) {
+		tcb.SendWindow = tcb.peerWindow(header)
+		tcb.SendWL1 = seq
+		tcb.SendWL2 = ack
+	}
+}
+
+// acceptable performs the segment acceptability test of RFC 793 3.3 for a
+// segment starting at seq that occupies length sequence numbers
+func (tcb *TCB) acceptable(seq, length uint32) bool {
+	wnd := tcb.RecvWindow
+	switch {
+	case length == 0 && wnd == 0:
+		return seq == tcb.RecvNext
+	case length == 0:
+		return SeqInRange(seq, tcb.RecvNext, tcb.RecvNext+wnd)
+	case wnd == 0:
+		return false
+	default:
+		// 先頭か末尾のどちらかがウィンドウ内にあればよい
+		return SeqInRange(seq, tcb.RecvNext, tcb.RecvNext+wnd) ||
+			SeqInRange(seq+length-1, tcb.RecvNext, tcb.RecvNext+wnd)
+	}
+}
+
+// ackSendBuffer drops the data acknowledged by ack from the send buffer
+func (tcb *TCB) ackSendBuffer(ack uint32) {
+	// FINの分だけACKがバッファより先に進むことがある
+	n := min(int(ack-tcb.SendUnack), len(tcb.SendBuffer))
+	tcb.SendBuffer = tcb.SendBuffer[n:]
+}
+
+// acknowledge removes the segments covered by ack from the retransmission
+// queue. The newest of them that was sent only once gives an RTT sample;
+// retransmitted segments are ambiguous and skipped (Karn's algorithm).
+func (tcb *TCB) acknowledge(ack uint32) {
+	now := time.Now()
+	var rtt time.Duration
+	for _, entry := range tcb.RetransmissionQueue.Remove(ack) {
+		if entry.Attempts == 1 && !entry.restarted {
+			rtt = now.Sub(entry.SentTime)
+		}
+	}
+	if rtt > 0 {
+		tcb.updateRTO(rtt)
+	}
+}
+
+// updateRTO folds an RTT sample into SRTT and RTTVAR and recomputes the
+// retransmission timeout (RFC 6298 2)
+func (tcb *TCB) updateRTO(rtt time.Duration) {
+	if tcb.SRTT == 0 {
+		tcb.SRTT = rtt
+		tcb.RTTVAR = rtt / 2
+	} else {
+		diff := tcb.SRTT - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		tcb.RTTVAR = (3*tcb.RTTVAR + diff) / 4
+		tcb.SRTT = (7*tcb.SRTT + rtt) / 8
+	}
+
+	// Gはタイマーの粒度
+	rto := tcb.SRTT + max(tickInterval, 4*tcb.RTTVAR)
+	tcb.RetransmissionTimeout = min(max(rto, tcb.MinRetransmissionTimeout), tcb.MaxRetransmissionTimeout)
+}
+
+// backoffRTO doubles the retransmission timeout after a timeout, up to
+// MaxRetransmissionTimeout (RFC 6298 5.5). The backed-off value is kept
+// until an ACK for a segment that was not retransmitted gives a new sample.
+func (tcb *TCB) backoffRTO() {
+	tcb.RetransmissionTimeout = min(2*tcb.RetransmissionTimeout, tcb.MaxRetransmissionTimeout)
+}
+
+// IsSynchronized returns true once the handshake has completed, i.e. in
+// every state from ESTABLISHED up to TIME_WAIT
+func (tcb *TCB) IsSynchronized() bool {
+	switch tcb.State {
+	case socket.StateEstablished, socket.StateFinWait1, socket.StateFinWait2,
+		socket.StateCloseWait, socket.StateClosing, socket.StateLastAck, socket.StateTimeWait:
+		return true
+	}
+	return false
+}
+
 // SetState sets the state of the TCB
 func (tcb *TCB) SetState(state socket.SocketState) {
 	tcb.State = state
@@ -296,28 +683,35 @@ func NewDataTransfer(tcb *TCB) *DataTransfer {
 	return &DataTransfer{tcb: tcb}
 }
 
-// Send sends data and returns a TCP packet with the data
+// Send sends data and returns a TCP packet with the data.
+// The data must fit into the peer's window (see UsableWindow).
 func (dt *DataTransfer) Send(data []byte) (*packet.TCPHeader, error) {
-	if dt.tcb.State != socket.StateEstablished {
-		return nil, fmt.Errorf("connection must be in ESTABLISHED state to send data")
+	// CLOSE_WAITでは相手のFIN後もアプリケーションが閉じるまで送信できる
+	if dt.tcb.State != socket.StateEstablished && dt.tcb.State != socket.StateCloseWait {
+		return nil, fmt.Errorf("connection must be in ESTABLISHED or CLOSE_WAIT state to send data")
 	}
 
 	if len(data) == 0 {
 		return nil, fmt.Errorf("cannot send empty data")
 	}
 
-	// Create data packet
-	header := packet.NewTCPHeader(
-		uint16(dt.tcb.LocalAddr.Port),
-		uint16(dt.tcb.RemoteAddr.Port),
-	)
+	// 相手が広告したウィンドウ（SND.UNA+SND.WND）を超えて送信しない
+	if usable := dt.UsableWindow(); len(data) > usable {
+		return nil, fmt.Errorf("data exceeds send window: %d bytes, %d usable", len(data), usable)
+	}
+
+	return dt.send(data), nil
+}
 
+// send creates a data segment at SendNext and keeps the data until it is
+// acknowledged
+func (dt *DataTransfer) send(data []byte) *packet.TCPHeader {
+	// Create data packet
+	header := dt.tcb.newHeader(packet.FlagACK | packet.FlagPSH) // ACK + PSH for data
 	header.SequenceNumber = dt.tcb.SendNext
 	header.AckNumber = dt.tcb.RecvNext
-	header.SetFlag(packet.FlagACK | packet.FlagPSH) // ACK + PSH for data
-	header.WindowSize = dt.tcb.RecvWindow
 
-	// データを送信バッファに追加（学習目的のためシンプルに）
+	// 確認応答されるまで送信バッファに保持する
 	dt.tcb.SendBuffer = append(dt.tcb.SendBuffer, data...)
 
 	// Add to retransmission queue
@@ -326,58 +720,146 @@ func (dt *DataTransfer) Send(data []byte) (*packet.TCPHeader, error) {
 	// シーケンス番号を更新（送信データ長分進める）
 	dt.tcb.SendNext += uint32(len(data))
 
-	return header, nil
+	return header
 }
 
-// Receive processes incoming data packet and returns received data
+// Probe sends the first unsent byte beyond a zero window so that the peer
+// answers with its current window (RFC 1122 4.2.2.17). It returns nil if
+// no data is queued.
+func (dt *DataTransfer) Probe() (*packet.TCPHeader, []byte) {
+	if dt.tcb.State != socket.StateEstablished && dt.tcb.State != socket.StateCloseWait {
+		return nil, nil
+	}
+	if len(dt.tcb.Unsent) == 0 {
+		return nil, nil
+	}
+	data := []byte{dt.tcb.Unsent[0]}
+	dt.tcb.Unsent = dt.tcb.Unsent[1:]
+	return dt.send(data), data
+}
+
+// Queue appends as much of data to the unsent queue as the send buffer has
+// room for and returns the number of bytes queued
+func (dt *DataTransfer) Queue(data []byte) int {
+	n := min(len(data), dt.SendBufferSpace())
+	dt.tcb.Unsent = append(dt.tcb.Unsent, data[:n]...)
+	return n
+}
+
+// NextSegment sends the next full-sized segment (see SegmentSize) from the
+// unsent queue as far as the peer's window allows. It returns nil when
+// there is nothing to send or the window is full.
+func (dt *DataTransfer) NextSegment() (*packet.TCPHeader, []byte) {
+	n := min(len(dt.tcb.Unsent), dt.UsableWindow(), dt.tcb.SegmentSize())
+	if n == 0 {
+		return nil, nil
+	}
+
+	// 再送に備えてキューとは別のバッファにコピーする
+	data := append([]byte(nil), dt.tcb.Unsent[:n]...)
+	header, err := dt.Send(data)
+	if err != nil {
+		return nil, nil
+	}
+	dt.tcb.Unsent = dt.tcb.Unsent[n:]
+	return header, data
+}
+
+// SegmentSize returns the largest payload of a data segment: SendMSS less
+// the TCP options every segment carries (RFC 6691)
+func (tcb *TCB) SegmentSize() int {
+	options := tcb.newHeader(packet.FlagACK).HeaderLength() - packet.MinHeaderLength
+	return max(int(tcb.SendMSS)-options, 1)
+}
+
+// UsableWindow returns how many more bytes the peer's window allows to be
+// sent: SND.UNA + SND.WND - SND.NXT
+func (dt *DataTransfer) UsableWindow() int {
+	end := dt.tcb.SendUnack + dt.tcb.SendWindow
+	if SeqLEQ(end, dt.tcb.SendNext) {
+		return 0
+	}
+	return int(end - dt.tcb.SendNext)
+}
+
+// Receive processes incoming data packet and returns received data.
+// A segment failing the acceptability test (RFC 793 3.3) is dropped and
+// answered with a duplicate ACK instead of an error. Bytes already received
+// at the left edge and bytes beyond the right edge of the receive window
+// are trimmed. In-window data beyond RecvNext is held in the reassembly
+// queue; once the gap is filled, all contiguous data is delivered.
 func (dt *DataTransfer) Receive(header *packet.TCPHeader, data []byte) ([]byte, *packet.TCPHeader, error) {
-	if dt.tcb.State != socket.StateEstablished {
+	// 自分がFINを送った後も相手のFINまではデータを受信できる
+	switch dt.tcb.State {
+	case socket.StateEstablished, socket.StateFinWait1, socket.StateFinWait2:
+	default:
 		return nil, nil, fmt.Errorf("connection must be in ESTABLISHED state to receive data")
 	}
 
-	// シーケンス番号の検証
-	if header.SequenceNumber != dt.tcb.RecvNext {
-		return nil, nil, fmt.Errorf("out-of-order packet: expected seq %d, got %d",
-			dt.tcb.RecvNext, header.SequenceNumber)
+	if err := dt.tcb.checkSegment(header, data); err != nil {
+		return nil, nil, err
+	}
+
+	// 受け入れられないセグメントには重複ACKで期待するシーケンス番号を再通知する
+	seq := header.SequenceNumber
+	if !dt.tcb.acceptable(seq, uint32(len(data))) {
+		return nil, dt.tcb.newAck(), nil
+	}
+
+	// 受信済みの部分（左端）とウィンドウを超える部分（右端）を取り除く
+	if SeqLT(seq, dt.tcb.RecvNext) {
+		data = data[dt.tcb.RecvNext-seq:]
+		seq = dt.tcb.RecvNext
+	}
+	if end := dt.tcb.RecvNext + dt.tcb.RecvWindow; SeqGT(seq+uint32(len(data)), end) {
+		data = data[:end-seq]
+	}
+
+	// ウィンドウ内の順序外データは隙間が埋まるまで再構築キューに保持し、重複ACKを返す
+	if seq != dt.tcb.RecvNext {
+		dt.tcb.ReassemblyQueue.Insert(seq, data)
+		return nil, dt.tcb.newAck(), nil
+	}
+
+	// 隙間が埋まったら、キューに保持していた続きのデータもまとめて受け取る
+	if rest := dt.tcb.ReassemblyQueue.Pop(seq + uint32(len(data))); len(rest) > 0 {
+		data = append(append([]byte(nil), data...), rest...)
 	}
 
 	// データを受信バッファに追加
 	dt.tcb.RecvBuffer = append(dt.tcb.RecvBuffer, data...)
 
-	// 受信シーケンス番号を更新
+	// 受信シーケンス番号を更新し、右端が動かないよう受け取った分だけウィンドウを縮める
 	dt.tcb.RecvNext += uint32(len(data))
+	dt.tcb.RecvWindow -= min(dt.tcb.RecvWindow, uint32(len(data)))
 
-	// ACKパケットを作成
-	ackHeader := packet.NewTCPHeader(
-		uint16(dt.tcb.LocalAddr.Port),
-		uint16(dt.tcb.RemoteAddr.Port),
-	)
-
-	ackHeader.SequenceNumber = dt.tcb.SendNext
-	ackHeader.AckNumber = dt.tcb.RecvNext // 更新された受信シーケンス番号
-	ackHeader.SetFlag(packet.FlagACK)
-	ackHeader.WindowSize = dt.tcb.RecvWindow
-
-	return data, ackHeader, nil
+	// ACKパケットを作成（更新された受信シーケンス番号）
+	return data, dt.tcb.newAck(), nil
 }
 
 // ReceiveAck processes incoming ACK packet for sent data
 func (dt *DataTransfer) ReceiveAck(header *packet.TCPHeader) error {
-	if dt.tcb.State != socket.StateEstablished {
-		return fmt.Errorf("connection must be in ESTABLISHED state to process ACK")
+	if !dt.tcb.IsSynchronized() {
+		return fmt.Errorf("connection must be synchronized to process ACK")
+	}
+
+	if err := dt.tcb.checkSegment(header, nil); err != nil {
+		return err
 	}
 
 	// ACK番号の検証
-	if header.AckNumber < dt.tcb.SendUnack || header.AckNumber > dt.tcb.SendNext {
+	if SeqLT(header.AckNumber, dt.tcb.SendUnack) || SeqGT(header.AckNumber, dt.tcb.SendNext) {
 		return fmt.Errorf("invalid ACK number: %d (expected between %d and %d)",
 			header.AckNumber, dt.tcb.SendUnack, dt.tcb.SendNext)
 	}
 
 	// 確認済みデータの更新
+	dt.tcb.ackSendBuffer(header.AckNumber)
 	dt.tcb.SendUnack = header.AckNumber
+	dt.tcb.updateSendWindow(header)
 
 	// Remove acknowledged packets from retransmission queue
-	dt.tcb.RetransmissionQueue.Remove(header.AckNumber)
+	dt.tcb.acknowledge(header.AckNumber)
 
 	return nil
 }
@@ -392,17 +874,34 @@ func (dt *DataTransfer) GetReceiveBuffer() []byte {
 	return append([]byte(nil), dt.tcb.RecvBuffer...)
 }
 
+// Read moves up to len(b) bytes from the receive buffer into b
+func (dt *DataTransfer) Read(b []byte) int {
+	n := copy(b, dt.tcb.RecvBuffer)
+	dt.tcb.RecvBuffer = dt.tcb.RecvBuffer[n:]
+	return n
+}
+
+// SendBufferSpace returns how many bytes can be queued before the send
+// buffer is full
+func (dt *DataTransfer) SendBufferSpace() int {
+	return max(dt.tcb.SendBufferSize-len(dt.tcb.SendBuffer)-len(dt.tcb.Unsent), 0)
+}
+
 // ClearReceiveBuffer clears the receive buffer (after application reads data)
 func (dt *DataTransfer) ClearReceiveBuffer() {
 	dt.tcb.RecvBuffer = dt.tcb.RecvBuffer[:0]
 }
 
-// CheckRetransmissions checks for packets that need retransmission
+// CheckRetransmissions checks for packets that need retransmission.
+// Each timeout doubles the retransmission timeout.
 func (dt *DataTransfer) CheckRetransmissions() ([]RetransmissionEntry, error) {
 	timeoutEntries := dt.tcb.RetransmissionQueue.GetTimeoutEntries(
 		dt.tcb.RetransmissionTimeout,
 		dt.tcb.MaxRetransmissionAttempts,
 	)
+	if len(timeoutEntries) > 0 {
+		dt.tcb.backoffRTO()
+	}
 	return timeoutEntries, nil
 }
 
@@ -438,14 +937,9 @@ func (h *FourWayHandshake) Close() (*packet.TCPHeader, error) {
 	}
 
 	// Create FIN packet
-	finHeader := packet.NewTCPHeader(
-		uint16(h.tcb.LocalAddr.Port),
-		uint16(h.tcb.RemoteAddr.Port),
-	)
+	finHeader := h.tcb.newHeader(packet.FlagFIN | packet.FlagACK)
 	finHeader.SequenceNumber = h.tcb.SendNext
 	finHeader.AckNumber = h.tcb.RecvNext
-	finHeader.SetFlag(packet.FlagFIN | packet.FlagACK)
-	finHeader.WindowSize = h.tcb.RecvWindow
 
 	// Add FIN packet to retransmission queue
 	h.tcb.RetransmissionQueue.Add(finHeader, nil)
@@ -465,6 +959,10 @@ func (h *FourWayHandshake) HandleFin(finHeader *packet.TCPHeader) (*packet.TCPHe
 		return nil, fmt.Errorf("unexpected FIN in state %s", h.tcb.State.String())
 	}
 
+	if err := h.tcb.checkSegment(finHeader, nil); err != nil {
+		return nil, err
+	}
+
 	// Verify sequence number
 	if finHeader.SequenceNumber != h.tcb.RecvNext {
 		return nil, fmt.Errorf("unexpected sequence number in FIN: expected %d, got %d",
@@ -475,14 +973,9 @@ func (h *FourWayHandshake) HandleFin(finHeader *packet.TCPHeader) (*packet.TCPHe
 	h.tcb.RecvNext++
 
 	// Create ACK for FIN
-	ackHeader := packet.NewTCPHeader(
-		uint16(h.tcb.LocalAddr.Port),
-		uint16(h.tcb.RemoteAddr.Port),
-	)
+	ackHeader := h.tcb.newHeader(packet.FlagACK)
 	ackHeader.SequenceNumber = h.tcb.SendNext
 	ackHeader.AckNumber = h.tcb.RecvNext
-	ackHeader.SetFlag(packet.FlagACK)
-	ackHeader.WindowSize = h.tcb.RecvWindow
 
 	// State transition depends on current state
 	switch h.tcb.State {
@@ -506,6 +999,10 @@ func (h *FourWayHandshake) HandleFinAck(ackHeader *packet.TCPHeader) error {
 		return fmt.Errorf("unexpected FIN ACK in state %s", h.tcb.State.String())
 	}
 
+	if err := h.tcb.checkSegment(ackHeader, nil); err != nil {
+		return err
+	}
+
 	// Verify ACK number (should acknowledge our FIN)
 	if ackHeader.AckNumber != h.tcb.SendNext {
 		return fmt.Errorf("invalid ACK number for FIN: expected %d, got %d",
@@ -513,8 +1010,12 @@ func (h *FourWayHandshake) HandleFinAck(ackHeader *packet.TCPHeader) error {
 	}
 
 	// Update unacknowledged sequence number
+	h.tcb.ackSendBuffer(ackHeader.AckNumber)
 	h.tcb.SendUnack = ackHeader.AckNumber
 
+	// Remove FIN from retransmission queue
+	h.tcb.acknowledge(ackHeader.AckNumber)
+
 	// State transition depends on current state
 	switch h.tcb.State {
 	case socket.StateFinWait1:
@@ -538,14 +1039,12 @@ func (h *FourWayHandshake) CloseFromCloseWait() (*packet.TCPHeader, error) {
 	}
 
 	// Create FIN packet for final close
-	finHeader := packet.NewTCPHeader(
-		uint16(h.tcb.LocalAddr.Port),
-		uint16(h.tcb.RemoteAddr.Port),
-	)
+	finHeader := h.tcb.newHeader(packet.FlagFIN | packet.FlagACK)
 	finHeader.SequenceNumber = h.tcb.SendNext
 	finHeader.AckNumber = h.tcb.RecvNext
-	finHeader.SetFlag(packet.FlagFIN | packet.FlagACK)
-	finHeader.WindowSize = h.tcb.RecvWindow
+
+	// Add FIN packet to retransmission queue
+	h.tcb.RetransmissionQueue.Add(finHeader, nil)
 
 	// Update sequence number (FIN consumes one sequence number)
 	h.tcb.SendNext++
@@ -561,12 +1060,18 @@ func (h *FourWayHandshake) IsConnectionClosed() bool {
 	return h.tcb.State == socket.StateClosed
 }
 
-// CanSendData returns true if the connection can still send data
+// CanSendData returns true if the connection can still send data,
+// i.e. no FIN has been sent yet
 func (h *FourWayHandshake) CanSendData() bool {
-	return h.tcb.State == socket.StateEstablished
+	return h.tcb.State == socket.StateEstablished || h.tcb.State == socket.StateCloseWait
 }
 
-// CanReceiveData returns true if the connection can still receive data
+// CanReceiveData returns true if the connection can still receive data,
+// i.e. the peer's FIN has not been received yet
 func (h *FourWayHandshake) CanReceiveData() bool {
-	return h.tcb.State == socket.StateEstablished || h.tcb.State == socket.StateCloseWait
+	switch h.tcb.State {
+	case socket.StateEstablished, socket.StateFinWait1, socket.StateFinWait2:
+		return true
+	}
+	return false
 }
@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"errors"
 	"net"
 	"testing"
 
@@ -23,8 +24,8 @@ func TestTCB_NewTCB(t *testing.T) {
 	if tcb.State != socket.StateClosed {
 		t.Errorf("Expected initial state CLOSED, got %s", tcb.State.String())
 	}
-	if tcb.RecvWindow != 65535 {
-		t.Errorf("Expected receive window 65535, got %d", tcb.RecvWindow)
+	if tcb.RecvWindow != DefaultRecvBufferSize {
+		t.Errorf("Expected receive window %d, got %d", DefaultRecvBufferSize, tcb.RecvWindow)
 	}
 }
 
@@ -188,6 +189,8 @@ func TestDataTransfer_Send(t *testing.T) {
 	tcb := NewTCB(localAddr, remoteAddr)
 	tcb.State = socket.StateEstablished
 	tcb.SendNext = 1000
+	tcb.SendUnack = 1000
+	tcb.SendWindow = 65535
 	tcb.RecvNext = 2000
 
 	dt := NewDataTransfer(tcb)
@@ -338,9 +341,295 @@ func TestDataTransfer_OutOfOrderPacket(t *testing.T) {
 	outOfOrderHeader := packet.NewTCPHeader(9090, 8080)
 	outOfOrderHeader.SequenceNumber = 2050 // 期待より大きい
 
-	_, _, err := dt.Receive(outOfOrderHeader, []byte("out of order"))
-	if err == nil {
-		t.Error("Expected error for out-of-order packet")
+	// エラーではなく期待するシーケンス番号を示す重複ACKが返る
+	data, ack, err := dt.Receive(outOfOrderHeader, []byte("out of order"))
+	if err != nil {
+		t.Fatalf("Unexpected error for out-of-order packet: %v", err)
+	}
+	if len(data) != 0 {
+		t.Errorf("Expected no data to be delivered, got %q", data)
+	}
+	if ack == nil || ack.AckNumber != 2000 {
+		t.Errorf("Expected duplicate ACK for 2000, got %v", ack)
+	}
+	if tcb.RecvNext != 2000 || len(tcb.RecvBuffer) != 0 {
+		t.Errorf("Expected receive state unchanged, got RecvNext %d and %d buffered bytes", tcb.RecvNext, len(tcb.RecvBuffer))
+	}
+	// 順序外のデータは再構築キューに保持される
+	if tcb.ReassemblyQueue.Len() != len("out of order") {
+		t.Errorf("Expected %d bytes in reassembly queue, got %d", len("out of order"), tcb.ReassemblyQueue.Len())
+	}
+}
+
+func TestDataTransfer_ReassemblesOutOfOrderData(t *testing.T) {
+	localAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:8080")
+	remoteAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:9090")
+
+	tcb := NewTCB(localAddr, remoteAddr)
+	tcb.State = socket.StateEstablished
+	tcb.RecvNext = 1000
+
+	dt := NewDataTransfer(tcb)
+
+	receive := func(seq uint32, payload string) ([]byte, *packet.TCPHeader) {
+		header := packet.NewTCPHeader(9090, 8080)
+		header.SequenceNumber = seq
+		data, ack, err := dt.Receive(header, []byte(payload))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return data, ack
+	}
+
+	// 3番目、2番目の順に届いたセグメントは保持され、重複ACKが返る
+	for _, segment := range []struct {
+		seq     uint32
+		payload string
+	}{{1010, "cccccccccc"}, {1005, "bbbbb"}} {
+		data, ack := receive(segment.seq, segment.payload)
+		if len(data) != 0 || ack.AckNumber != 1000 {
+			t.Fatalf("Expected duplicate ACK for 1000 and no data, got ACK %d and %q", ack.AckNumber, data)
+		}
+	}
+
+	// 先頭が埋まると続きのデータもまとめて配送される
+	data, ack := receive(1000, "aaaaa")
+	if string(data) != "aaaaabbbbbcccccccccc" {
+		t.Errorf("Expected all contiguous data, got %q", data)
+	}
+	if ack.AckNumber != 1020 || tcb.RecvNext != 1020 {
+		t.Errorf("Expected ACK and RecvNext 1020, got %d and %d", ack.AckNumber, tcb.RecvNext)
+	}
+	if string(tcb.RecvBuffer) != "aaaaabbbbbcccccccccc" {
+		t.Errorf("Expected receive buffer to hold all data, got %q", tcb.RecvBuffer)
+	}
+	if tcb.ReassemblyQueue.Len() != 0 {
+		t.Errorf("Expected empty reassembly queue, got %d bytes", tcb.ReassemblyQueue.Len())
+	}
+}
+
+func TestTCB_Acceptable(t *testing.T) {
+	tcb := NewTCB(nil, nil)
+	tcb.RecvNext = 1000
+
+	tests := []struct {
+		name     string
+		window   uint32
+		seq      uint32
+		length   uint32
+		expected bool
+	}{
+		// 長さ0・ウィンドウ0: RCV.NXTちょうどのみ
+		{"empty, zero window, at RCV.NXT", 0, 1000, 0, true},
+		{"empty, zero window, after RCV.NXT", 0, 1001, 0, false},
+		// 長さ0・ウィンドウあり: ウィンドウ内
+		{"empty, in window", 100, 1050, 0, true},
+		{"empty, before window", 100, 999, 0, false},
+		{"empty, at right edge", 100, 1100, 0, false},
+		// 長さあり・ウィンドウ0: 受け入れない
+		{"data, zero window", 0, 1000, 10, false},
+		// 長さあり・ウィンドウあり: 先頭か末尾がウィンドウ内
+		{"data, in window", 100, 1000, 10, true},
+		{"data, overlapping left edge", 100, 995, 10, true},
+		{"data, overlapping right edge", 100, 1095, 10, true},
+		{"data, entirely old", 100, 990, 10, false},
+		{"data, beyond window", 100, 1100, 10, false},
+	}
+
+	for _, test := range tests {
+		tcb.RecvWindow = test.window
+		if got := tcb.acceptable(test.seq, test.length); got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestDataTransfer_ReceiveTrimming(t *testing.T) {
+	localAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:8080")
+	remoteAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:9090")
+
+	tcb := NewTCB(localAddr, remoteAddr)
+	tcb.State = socket.StateEstablished
+	tcb.RecvNext = 2000
+	tcb.RecvWindow = 10
+	dt := NewDataTransfer(tcb)
+
+	header := packet.NewTCPHeader(9090, 8080)
+
+	// 左端: 受信済みの "abc" を取り除いて残りを受け取る
+	header.SequenceNumber = 1997
+	data, ack, err := dt.Receive(header, []byte("abcdef"))
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if string(data) != "def" || ack.AckNumber != 2003 {
+		t.Errorf("Expected %q acked up to 2003, got %q and %d", "def", data, ack.AckNumber)
+	}
+
+	// 右端: 最初に広告したウィンドウの右端（2010）を超える部分は捨てる
+	if ack.WindowSize != 7 {
+		t.Errorf("Expected window to shrink to 7, got %d", ack.WindowSize)
+	}
+	header.SequenceNumber = 2003
+	data, ack, err = dt.Receive(header, []byte("0123456789XYZ"))
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if string(data) != "0123456" || ack.AckNumber != 2010 || ack.WindowSize != 0 {
+		t.Errorf("Expected %q acked up to 2010 with zero window, got %q, %d and window %d",
+			"0123456", data, ack.AckNumber, ack.WindowSize)
+	}
+
+	// 完全に重複したセグメントには重複ACKを返す
+	header.SequenceNumber = 2003
+	data, ack, err = dt.Receive(header, []byte("0123"))
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if len(data) != 0 || ack.AckNumber != 2010 {
+		t.Errorf("Expected duplicate ACK for 2010, got %q and %d", data, ack.AckNumber)
+	}
+
+	if string(tcb.RecvBuffer) != "def0123456" {
+		t.Errorf("Expected receive buffer %q, got %q", "def0123456", tcb.RecvBuffer)
+	}
+}
+
+func TestTCB_UpdateSendWindow(t *testing.T) {
+	tcb := NewTCB(nil, nil)
+	tcb.SendUnack = 1000
+	tcb.SendNext = 1500
+	tcb.SendWindow = 500
+	tcb.SendWL1 = 5000
+	tcb.SendWL2 = 1000
+
+	update := func(seq, ack uint32, window uint16) {
+		header := packet.NewTCPHeader(9090, 8080)
+		header.SetFlag(packet.FlagACK)
+		header.SequenceNumber = seq
+		header.AckNumber = ack
+		header.WindowSize = window
+		tcb.updateSendWindow(header)
+	}
+
+	// 新しいセグメントのウィンドウで更新する
+	update(5000, 1200, 3000)
+	if tcb.SendWindow != 3000 || tcb.SendWL1 != 5000 || tcb.SendWL2 != 1200 {
+		t.Errorf("Expected window 3000 from (5000, 1200), got %d from (%d, %d)", tcb.SendWindow, tcb.SendWL1, tcb.SendWL2)
+	}
+
+	// 前回の更新より古いセグメントは無視する
+	update(4999, 1300, 100)
+	update(5000, 1100, 100)
+	if tcb.SendWindow != 3000 {
+		t.Errorf("Expected window from an older segment to be ignored, got %d", tcb.SendWindow)
+	}
+
+	// 未送信データへのACKは無視する
+	update(5001, 1600, 100)
+	if tcb.SendWindow != 3000 {
+		t.Errorf("Expected window from an invalid ACK to be ignored, got %d", tcb.SendWindow)
+	}
+
+	// ゼロウィンドウも受け入れる
+	update(5001, 1500, 0)
+	if tcb.SendWindow != 0 {
+		t.Errorf("Expected zero window, got %d", tcb.SendWindow)
+	}
+}
+
+func TestDataTransfer_SendWindow(t *testing.T) {
+	localAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:8080")
+	remoteAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:9090")
+
+	tcb := NewTCB(localAddr, remoteAddr)
+	tcb.State = socket.StateEstablished
+	tcb.SendNext = 1000
+	tcb.SendUnack = 1000
+	tcb.SendWindow = 100
+	tcb.RecvNext = 2000
+	dt := NewDataTransfer(tcb)
+
+	// ウィンドウを超えるデータは送信できない
+	if _, err := dt.Send(make([]byte, 101)); err == nil {
+		t.Error("Expected error when sending beyond the send window")
+	}
+
+	// キューに入れたデータはウィンドウの分だけ送られる
+	if n := dt.Queue(make([]byte, 300)); n != 300 {
+		t.Fatalf("Expected 300 bytes queued, got %d", n)
+	}
+	header, data := dt.NextSegment()
+	if header == nil || header.SequenceNumber != 1000 || len(data) != 100 {
+		t.Fatalf("Expected 100 bytes at 1000, got %v and %d bytes", header, len(data))
+	}
+	if header, _ := dt.NextSegment(); header != nil {
+		t.Errorf("Expected no segment with a full window, got %s", header)
+	}
+
+	// ACKでウィンドウが開くと続きを送れる
+	ack := packet.NewTCPHeader(9090, 8080)
+	ack.SetFlag(packet.FlagACK)
+	ack.SequenceNumber = 2000
+	ack.AckNumber = 1100
+	ack.WindowSize = 150
+	if err := dt.ReceiveAck(ack); err != nil {
+		t.Fatalf("ReceiveAck failed: %v", err)
+	}
+	if usable := dt.UsableWindow(); usable != 150 {
+		t.Errorf("Expected 150 usable bytes, got %d", usable)
+	}
+	header, data = dt.NextSegment()
+	if header == nil || header.SequenceNumber != 1100 || len(data) != 150 {
+		t.Fatalf("Expected 150 bytes at 1100, got %v and %d bytes", header, len(data))
+	}
+	if len(tcb.Unsent) != 50 || tcb.SendNext != 1250 {
+		t.Errorf("Expected 50 unsent bytes and SendNext 1250, got %d and %d", len(tcb.Unsent), tcb.SendNext)
+	}
+}
+
+func TestDataTransfer_SegmentSize(t *testing.T) {
+	localAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:8080")
+	remoteAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:9090")
+
+	tcb := NewTCB(localAddr, remoteAddr)
+	tcb.State = socket.StateEstablished
+	tcb.SendMSS = 1460
+	tcb.SendWindow = 65535
+	dt := NewDataTransfer(tcb)
+
+	if size := tcb.SegmentSize(); size != 1460 {
+		t.Errorf("Expected segment size 1460 without options, got %d", size)
+	}
+
+	// タイムスタンプの12バイトはMSSから差し引く（RFC 6691）
+	tcb.TimestampsOK = true
+	if size := tcb.SegmentSize(); size != 1448 {
+		t.Errorf("Expected segment size 1448 with timestamps, got %d", size)
+	}
+	dt.Queue(make([]byte, 3000))
+	header, data := dt.NextSegment()
+	if header == nil || len(data) != 1448 || header.HeaderLength()+len(data) != packet.MinHeaderLength+1460 {
+		t.Errorf("Expected 1448 bytes in a segment of %d bytes, got %d bytes", packet.MinHeaderLength+1460, len(data))
+	}
+}
+
+func TestTCB_UpdateRecvWindow(t *testing.T) {
+	tcb := NewTCB(nil, nil)
+	tcb.RecvBufferSize = 4000
+	tcb.MSS = 1460
+	tcb.RecvWindow = 0
+	tcb.RecvBuffer = make([]byte, 4000)
+
+	// 空きがMSSとバッファの半分の小さい方に満たない間はウィンドウを開かない（SWS回避）
+	tcb.RecvBuffer = tcb.RecvBuffer[1000:]
+	if tcb.updateRecvWindow() || tcb.RecvWindow != 0 {
+		t.Errorf("Expected window to stay closed, got %d", tcb.RecvWindow)
+	}
+
+	tcb.RecvBuffer = tcb.RecvBuffer[500:]
+	if !tcb.updateRecvWindow() || tcb.RecvWindow != 1500 {
+		t.Errorf("Expected window to open to 1500, got %d", tcb.RecvWindow)
 	}
 }
 
@@ -356,6 +645,8 @@ func TestDataTransfer_CompleteExchange(t *testing.T) {
 	senderTCB.State = socket.StateEstablished
 	receiverTCB.State = socket.StateEstablished
 	senderTCB.SendNext = 1000
+	senderTCB.SendUnack = 1000
+	senderTCB.SendWindow = 65535
 	senderTCB.RecvNext = 2000
 	receiverTCB.SendNext = 2000
 	receiverTCB.RecvNext = 1000
@@ -641,9 +932,9 @@ func TestFourWayHandshake_ConnectionState(t *testing.T) {
 		isClosed   bool
 	}{
 		{socket.StateEstablished, true, true, false},
-		{socket.StateFinWait1, false, false, false},
-		{socket.StateFinWait2, false, false, false},
-		{socket.StateCloseWait, false, true, false},
+		{socket.StateFinWait1, false, true, false},
+		{socket.StateFinWait2, false, true, false},
+		{socket.StateCloseWait, true, false, false},
 		{socket.StateClosing, false, false, false},
 		{socket.StateLastAck, false, false, false},
 		{socket.StateTimeWait, false, false, false},
@@ -669,3 +960,236 @@ func TestFourWayHandshake_ConnectionState(t *testing.T) {
 		}
 	}
 }
+
+func TestChecksumVerification(t *testing.T) {
+	localAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:8080")
+	remoteAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:9090")
+
+	tcb := NewTCB(localAddr, remoteAddr)
+	tcb.State = socket.StateEstablished
+	tcb.RecvNext = 2000
+	tcb.VerifyChecksum = true
+
+	dt := NewDataTransfer(tcb)
+
+	header := packet.NewTCPHeader(9090, 8080)
+	header.SequenceNumber = 2000
+	header.SetFlag(packet.FlagACK | packet.FlagPSH)
+	data := []byte("intact")
+	if err := header.SetChecksum(remoteAddr.IP, localAddr.IP, data); err != nil {
+		t.Fatalf("Failed to set checksum: %v", err)
+	}
+
+	// 破損したペイロードは破棄される
+	_, _, err := dt.Receive(header, []byte("broken"))
+	if !errors.Is(err, packet.ErrBadChecksum) {
+		t.Fatalf("Expected checksum error, got %v", err)
+	}
+	if tcb.ChecksumErrors != 1 {
+		t.Errorf("Expected 1 checksum error, got %d", tcb.ChecksumErrors)
+	}
+	if tcb.RecvNext != 2000 {
+		t.Errorf("Corrupted segment must not advance RecvNext, got %d", tcb.RecvNext)
+	}
+
+	// 正しいセグメントは受理される
+	if _, _, err := dt.Receive(header, data); err != nil {
+		t.Fatalf("Failed to receive valid segment: %v", err)
+	}
+	if tcb.ChecksumErrors != 1 {
+		t.Errorf("Expected checksum error count to stay 1, got %d", tcb.ChecksumErrors)
+	}
+
+	// ハンドシェイクでも破損したSYNは破棄される
+	serverTCB := NewTCB(localAddr, remoteAddr)
+	serverTCB.State = socket.StateListen
+	serverTCB.VerifyChecksum = true
+	syn := packet.NewTCPHeader(9090, 8080)
+	syn.SetFlag(packet.FlagSYN)
+	syn.Checksum = 0xdead
+	if _, err := NewThreeWayHandshake(serverTCB).HandleSyn(syn); !errors.Is(err, packet.ErrBadChecksum) {
+		t.Errorf("Expected checksum error for SYN, got %v", err)
+	}
+	if serverTCB.State != socket.StateListen || serverTCB.ChecksumErrors != 1 {
+		t.Errorf("Expected LISTEN with 1 checksum error, got %s with %d", serverTCB.State, serverTCB.ChecksumErrors)
+	}
+
+	// 相手のアドレスを知らない待ち受けTCBは擬似ヘッダーを作れないので検証しない
+	anyTCB := NewTCB(localAddr, nil)
+	anyTCB.State = socket.StateListen
+	anyTCB.VerifyChecksum = true
+	if err := anyTCB.checkSegment(syn, nil); err != nil || anyTCB.ChecksumErrors != 0 {
+		t.Errorf("Expected SYN to pass without a remote address, got %v", err)
+	}
+}
+
+func TestThreeWayHandshake_OptionNegotiation(t *testing.T) {
+	clientAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:8080")
+	serverAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:9090")
+
+	clientTCB := NewTCB(clientAddr, serverAddr)
+	serverTCB := NewTCB(serverAddr, clientAddr)
+	serverTCB.State = socket.StateListen
+	serverTCB.MSS = 1200
+
+	clientHandshake := NewThreeWayHandshake(clientTCB)
+	serverHandshake := NewThreeWayHandshake(serverTCB)
+
+	synPacket, err := clientHandshake.StartClient()
+	if err != nil {
+		t.Fatalf("Failed to start client handshake: %v", err)
+	}
+	for _, kind := range []uint8{packet.OptionKindMSS, packet.OptionKindWindowScale,
+		packet.OptionKindSACKPermitted, packet.OptionKindTimestamps} {
+		if synPacket.FindOption(kind) == nil {
+			t.Errorf("SYN should advertise option kind %d", kind)
+		}
+	}
+
+	// オプションはワイヤ形式を経由しても保持される
+	b, err := synPacket.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal SYN: %v", err)
+	}
+	decodedSyn, _, err := packet.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal SYN: %v", err)
+	}
+
+	synAckPacket, err := serverHandshake.HandleSyn(decodedSyn)
+	if err != nil {
+		t.Fatalf("Failed to handle SYN: %v", err)
+	}
+	if mss, ok := synAckPacket.FindOption(packet.OptionKindMSS).(packet.MSSOption); !ok || mss.MSS != 1200 {
+		t.Errorf("SYN-ACK should advertise MSS 1200, got %#v", synAckPacket.FindOption(packet.OptionKindMSS))
+	}
+	if serverTCB.SendMSS != 1200 {
+		t.Errorf("Expected server SendMSS 1200, got %d", serverTCB.SendMSS)
+	}
+	if !serverTCB.SACKPermitted || !serverTCB.TimestampsOK || !serverTCB.WindowScaleOK {
+		t.Errorf("Server should have negotiated SACK, timestamps and window scale: %+v", serverTCB)
+	}
+
+	ackPacket, err := clientHandshake.HandleSynAck(synAckPacket)
+	if err != nil {
+		t.Fatalf("Failed to handle SYN-ACK: %v", err)
+	}
+	if clientTCB.SendMSS != 1200 {
+		t.Errorf("Expected client SendMSS 1200, got %d", clientTCB.SendMSS)
+	}
+	if !clientTCB.SACKPermitted || !clientTCB.TimestampsOK || !clientTCB.WindowScaleOK {
+		t.Errorf("Client should have negotiated SACK, timestamps and window scale: %+v", clientTCB)
+	}
+
+	// 以降のセグメントはタイムスタンプを運び、相手のTSvalをエコーする
+	ts, ok := ackPacket.FindOption(packet.OptionKindTimestamps).(packet.TimestampsOption)
+	if !ok {
+		t.Fatal("ACK should carry the timestamps option")
+	}
+	serverTS := synAckPacket.FindOption(packet.OptionKindTimestamps).(packet.TimestampsOption)
+	if ts.EchoReply != serverTS.Value {
+		t.Errorf("Expected TSecr %d, got %d", serverTS.Value, ts.EchoReply)
+	}
+	if ackPacket.FindOption(packet.OptionKindMSS) != nil {
+		t.Error("MSS option must only appear on SYN segments")
+	}
+}
+
+func TestWindowShift(t *testing.T) {
+	tests := []struct {
+		size     int
+		expected uint8
+	}{
+		{65535, 0},
+		{65536, 1},
+		{1 << 20, 5},
+		{1 << 31, 14}, // RFC 7323の上限
+	}
+	for _, test := range tests {
+		if got := windowShift(test.size); got != test.expected {
+			t.Errorf("windowShift(%d) = %d, expected %d", test.size, got, test.expected)
+		}
+	}
+}
+
+func TestThreeWayHandshake_WindowScale(t *testing.T) {
+	serverAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:9090")
+	clientAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:8080")
+
+	// 両側とも1MiBの受信バッファを持つ
+	newTCB := func(local, remote *net.TCPAddr) *TCB {
+		tcb := NewTCB(local, remote)
+		tcb.RecvBufferSize = 1 << 20
+		tcb.RecvWindow = 1 << 20
+		tcb.RecvWindowShift = windowShift(1 << 20)
+		return tcb
+	}
+	clientTCB := newTCB(clientAddr, serverAddr)
+	serverTCB := newTCB(serverAddr, clientAddr)
+	serverTCB.State = socket.StateListen
+
+	syn, _ := NewThreeWayHandshake(clientTCB).StartClient()
+	if ws, ok := syn.FindOption(packet.OptionKindWindowScale).(packet.WindowScaleOption); !ok || ws.Shift != 5 {
+		t.Errorf("Expected SYN to advertise shift 5, got %#v", syn.FindOption(packet.OptionKindWindowScale))
+	}
+	// SYNのウィンドウはスケールしない
+	if syn.WindowSize != 65535 {
+		t.Errorf("Expected unscaled SYN window 65535, got %d", syn.WindowSize)
+	}
+
+	synAck, err := NewThreeWayHandshake(serverTCB).HandleSyn(syn)
+	if err != nil {
+		t.Fatalf("Failed to handle SYN: %v", err)
+	}
+	if synAck.WindowSize != 65535 || serverTCB.SendWindow != 65535 {
+		t.Errorf("Expected unscaled SYN-ACK window and SND.WND 65535, got %d and %d", synAck.WindowSize, serverTCB.SendWindow)
+	}
+
+	ack, err := NewThreeWayHandshake(clientTCB).HandleSynAck(synAck)
+	if err != nil {
+		t.Fatalf("Failed to handle SYN-ACK: %v", err)
+	}
+	// 合意後のセグメントはスケールしたウィンドウを運ぶ
+	if ack.WindowSize != (1<<20)>>5 {
+		t.Errorf("Expected scaled window %d, got %d", (1<<20)>>5, ack.WindowSize)
+	}
+	if err := NewThreeWayHandshake(serverTCB).HandleAck(ack); err != nil {
+		t.Fatalf("Failed to handle ACK: %v", err)
+	}
+	if serverTCB.SendWindow != 1<<20 {
+		t.Errorf("Expected server SND.WND of 1MiB, got %d", serverTCB.SendWindow)
+	}
+}
+
+func TestThreeWayHandshake_PeerWithoutOptions(t *testing.T) {
+	serverAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:9090")
+	clientAddr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:8080")
+
+	serverTCB := NewTCB(serverAddr, clientAddr)
+	serverTCB.State = socket.StateListen
+
+	// オプションなしのSYNにはMSSのみで応答する
+	synHeader := packet.NewTCPHeader(8080, 9090)
+	synHeader.SequenceNumber = 1000
+	synHeader.SetFlag(packet.FlagSYN)
+
+	synAckPacket, err := NewThreeWayHandshake(serverTCB).HandleSyn(synHeader)
+	if err != nil {
+		t.Fatalf("Failed to handle SYN: %v", err)
+	}
+	if len(synAckPacket.Options) != 1 || synAckPacket.FindOption(packet.OptionKindMSS) == nil {
+		t.Errorf("Expected only the MSS option, got %#v", synAckPacket.Options)
+	}
+	if serverTCB.SendMSS != DefaultSendMSS {
+		t.Errorf("Expected default SendMSS %d, got %d", DefaultSendMSS, serverTCB.SendMSS)
+	}
+	if serverTCB.SACKPermitted || serverTCB.TimestampsOK || serverTCB.WindowScaleOK {
+		t.Errorf("No options should have been negotiated: %+v", serverTCB)
+	}
+
+	// 相手が合意しなければ大きなバッファでもウィンドウはスケールしない
+	serverTCB.RecvWindow = 1 << 20
+	if ack := serverTCB.newAck(); ack.WindowSize != 65535 {
+		t.Errorf("Expected unscaled window 65535 without agreement, got %d", ack.WindowSize)
+	}
+}
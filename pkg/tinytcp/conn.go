@@ -0,0 +1,124 @@
+package tinytcp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/sasakihasuto/tinytcp/internal/socket"
+)
+
+var (
+	_ net.Conn     = (*Conn)(nil)
+	_ net.Listener = (*Listener)(nil)
+)
+
+// Conn is a TinyTCP connection. It implements net.Conn.
+type Conn struct {
+	sock   *socket.TinySocket
+	closed atomic.Bool
+}
+
+// Read reads data from the connection. It returns io.EOF once the peer has
+// closed the connection and all data has been read.
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.sock.Read(b)
+}
+
+// Write writes data to the connection, blocking until all of it has been
+// queued for transmission
+func (c *Conn) Write(b []byte) (int, error) {
+	return c.sock.Send(b)
+}
+
+// Close closes the connection, sending FIN to the peer.
+// Blocked Read and Write calls return net.ErrClosed.
+func (c *Conn) Close() error {
+	if c.closed.Swap(true) {
+		return c.closedError()
+	}
+	return c.sock.Close()
+}
+
+// Abort closes the connection by sending RST instead of FIN, discarding
+// data the peer has not acknowledged yet
+func (c *Conn) Abort() error {
+	if c.closed.Swap(true) {
+		return c.closedError()
+	}
+	return c.sock.Abort()
+}
+
+// closedError is returned when the connection is closed twice
+func (c *Conn) closedError() error {
+	return &net.OpError{Op: "close", Net: "tcp", Source: c.sock.LocalAddr(), Addr: c.sock.RemoteAddr(), Err: net.ErrClosed}
+}
+
+// CloseWrite shuts down the writing side of the connection by sending FIN.
+// Read keeps returning data until the peer closes its side.
+func (c *Conn) CloseWrite() error {
+	return c.sock.CloseWrite()
+}
+
+// CloseRead shuts down the reading side of the connection
+func (c *Conn) CloseRead() error {
+	return c.sock.CloseRead()
+}
+
+// LocalAddr returns the local network address
+func (c *Conn) LocalAddr() net.Addr {
+	return c.sock.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.sock.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.sock.SetDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for current and future Read calls
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.sock.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for current and future Write calls
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.sock.SetWriteDeadline(t)
+}
+
+// Listener is a TinyTCP listener. It implements net.Listener.
+type Listener struct {
+	sock   *socket.TinySocket
+	closed atomic.Bool
+}
+
+// Accept waits for and returns the next connection
+func (l *Listener) Accept() (net.Conn, error) {
+	sock, err := l.sock.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{sock: sock}, nil
+}
+
+// Close stops listening. Blocked Accept calls return net.ErrClosed.
+func (l *Listener) Close() error {
+	if l.closed.Swap(true) {
+		return &net.OpError{Op: "close", Net: "tcp", Addr: l.sock.LocalAddr(), Err: net.ErrClosed}
+	}
+	return l.sock.Close()
+}
+
+// Addr returns the listener's network address
+func (l *Listener) Addr() net.Addr {
+	return l.sock.LocalAddr()
+}
+
+// SetDeadline sets the deadline for current and future Accept calls
+func (l *Listener) SetDeadline(t time.Time) error {
+	return l.sock.SetReadDeadline(t)
+}
@@ -1,6 +1,114 @@
-// Package tinytcp provides public API for the TinyTCP implementation
+// Package tinytcp provides public API for the TinyTCP implementation.
+//
+// A Stack runs the TinyTCP protocol engine on one link. Its Dial and Listen
+// return connections implementing net.Conn and net.Listener, so code written
+// against the net package (net/http, bufio, io.Copy, ...) works unmodified.
+// The package-level Dial and Listen use the stack registered with SetDefault
+// and return ErrNoDefaultStack until one is set:
+//
+//	stack, err := tinytcp.NewUDPStack(net.IPv4(10, 0, 0, 1), "127.0.0.1:9001", "127.0.0.1:9000")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer stack.Close()
+//	tinytcp.SetDefault(stack)
+//
+//	conn, err := tinytcp.Dial(ctx, "10.0.0.2:8080")
 package tinytcp
 
-// TODO: 公開 API の実装をここに記述
-// - 外部から使用可能なインターフェース
-// - 簡単な使用例
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+
+	"github.com/sasakihasuto/tinytcp/internal/link"
+	"github.com/sasakihasuto/tinytcp/internal/socket"
+	"github.com/sasakihasuto/tinytcp/internal/tcp"
+)
+
+// defaultMTU is the MTU of in-memory and TUN links
+const defaultMTU = 1500
+
+// ErrNoDefaultStack is returned by Dial and Listen before SetDefault is called
+var ErrNoDefaultStack = errors.New("tinytcp: no default stack")
+
+// Stack is a TinyTCP network stack attached to one link
+type Stack struct {
+	tcp  *tcp.Stack
+	link link.LinkEndpoint
+}
+
+// newStack starts a stack on ep using addr as its local address
+func newStack(ep link.LinkEndpoint, addr net.IP) *Stack {
+	return &Stack{tcp: tcp.NewStack(ep, addr), link: ep}
+}
+
+// NewUDPStack creates a stack whose IP packets are tunnelled over UDP.
+// addr is the stack's own IP address; localUDP and remoteUDP are the UDP
+// addresses of the tunnel. An empty remoteUDP learns the peer from the
+// first datagram received.
+func NewUDPStack(addr net.IP, localUDP, remoteUDP string) (*Stack, error) {
+	ep, err := link.NewUDPEndpoint(localUDP, remoteUDP, link.DefaultUDPMTU)
+	if err != nil {
+		return nil, err
+	}
+	return newStack(ep, addr), nil
+}
+
+// NewPipe creates two stacks with addresses a and b connected by an
+// in-memory link
+func NewPipe(a, b net.IP) (*Stack, *Stack) {
+	epA, epB := link.NewPipe(defaultMTU)
+	return newStack(epA, a), newStack(epB, b)
+}
+
+// Dial connects to addr ("host:port" with a literal IP address)
+func (s *Stack) Dial(ctx context.Context, addr string) (*Conn, error) {
+	sock := socket.NewSocketWithTransport(s.tcp)
+	if err := sock.DialContext(ctx, addr); err != nil {
+		return nil, err
+	}
+	return &Conn{sock: sock}, nil
+}
+
+// Listen announces on the local address addr (e.g. ":8080")
+func (s *Stack) Listen(addr string) (*Listener, error) {
+	sock := socket.NewSocketWithTransport(s.tcp)
+	if err := sock.Listen(addr); err != nil {
+		return nil, err
+	}
+	return &Listener{sock: sock}, nil
+}
+
+// Close stops the stack and its link.
+// Open connections are dropped without notifying their peers.
+func (s *Stack) Close() error {
+	s.tcp.Close()
+	return s.link.Close()
+}
+
+var defaultStack atomic.Pointer[Stack]
+
+// SetDefault makes s the stack used by the package-level Dial and Listen
+func SetDefault(s *Stack) {
+	defaultStack.Store(s)
+}
+
+// Dial connects to addr using the default stack
+func Dial(ctx context.Context, addr string) (*Conn, error) {
+	s := defaultStack.Load()
+	if s == nil {
+		return nil, ErrNoDefaultStack
+	}
+	return s.Dial(ctx, addr)
+}
+
+// Listen announces on addr using the default stack
+func Listen(addr string) (*Listener, error) {
+	s := defaultStack.Load()
+	if s == nil {
+		return nil, ErrNoDefaultStack
+	}
+	return s.Listen(addr)
+}
@@ -0,0 +1,267 @@
+package tinytcp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+var (
+	clientIP = net.IPv4(10, 0, 0, 1)
+	serverIP = net.IPv4(10, 0, 0, 2)
+)
+
+// newPipe creates a connected client and server stack
+func newPipe(t *testing.T) (*Stack, *Stack) {
+	t.Helper()
+
+	client, server := NewPipe(clientIP, serverIP)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestHTTPOverTinyTCP(t *testing.T) {
+	client, server := newPipe(t)
+
+	l, err := server.Listen(":80")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s from %s", r.URL.Path, r.RemoteAddr)
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return client.Dial(ctx, addr)
+		},
+	}}
+	defer httpClient.CloseIdleConnections()
+
+	// 2回目のリクエストはキープアライブした接続を再利用する
+	for _, path := range []string{"/a", "/b"} {
+		resp, err := httpClient.Get("http://10.0.0.2:80" + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to read body: %v", err)
+		}
+
+		expected := fmt.Sprintf("hello %s from 10.0.0.1:", path)
+		if string(body[:min(len(body), len(expected))]) != expected {
+			t.Errorf("Expected body starting with %q, got %q", expected, body)
+		}
+	}
+}
+
+func TestEchoWithIOCopy(t *testing.T) {
+	client, server := newPipe(t)
+
+	l, err := server.Listen(":7")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, "10.0.0.2:7")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	w := bufio.NewWriter(conn)
+	r := bufio.NewReader(conn)
+	for i := range 100 {
+		fmt.Fprintf(w, "line %d\n", i)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i := range 100 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString failed: %v", err)
+		}
+		if expected := fmt.Sprintf("line %d\n", i); line != expected {
+			t.Fatalf("Expected %q, got %q", expected, line)
+		}
+	}
+}
+
+func TestCloseWrite(t *testing.T) {
+	client, server := newPipe(t)
+
+	l, err := server.Listen(":7")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	conn, err := client.Dial(context.Background(), "10.0.0.2:7")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// 送信側を閉じた後もエコーを最後まで読める
+	if _, err := conn.Write([]byte("half-close")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite failed: %v", err)
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(got) != "half-close" {
+		t.Errorf("Expected %q, got %q", "half-close", got)
+	}
+	if _, err := conn.Write([]byte("more")); err == nil {
+		t.Error("Expected error when writing after CloseWrite")
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	client, server := newPipe(t)
+
+	l, err := server.Listen(":80")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer l.Close()
+
+	conn, err := client.Dial(context.Background(), "10.0.0.2:80")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// 期限切れはnet.ErrorのTimeoutとして報告される
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected timeout error, got %v", err)
+	}
+
+	// ブロック中のReadは期限を過去に設定すると起こされる
+	conn.SetReadDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	conn.SetReadDeadline(time.Now().Add(-time.Second))
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("Expected os.ErrDeadlineExceeded, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read was not woken up by the deadline")
+	}
+
+	// Closeもブロック中のReadを起こす
+	conn.SetReadDeadline(time.Time{})
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Expected net.ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read was not woken up by Close")
+	}
+}
+
+func TestListenerClose(t *testing.T) {
+	_, server := newPipe(t)
+
+	l, err := server.Listen(":80")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	if addr := l.Addr().String(); addr != ":80" {
+		t.Errorf("Expected address :80, got %s", addr)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	l.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Expected net.ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept was not woken up by Close")
+	}
+}
+
+func TestDefaultStack(t *testing.T) {
+	if _, err := Dial(context.Background(), "10.0.0.2:80"); !errors.Is(err, ErrNoDefaultStack) {
+		t.Errorf("Expected ErrNoDefaultStack, got %v", err)
+	}
+
+	client, server := newPipe(t)
+	SetDefault(server)
+	defer SetDefault(nil)
+
+	l, err := Listen(":80")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer l.Close()
+
+	conn, err := client.Dial(context.Background(), "10.0.0.2:80")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn.Close()
+}
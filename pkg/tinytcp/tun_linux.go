@@ -0,0 +1,18 @@
+package tinytcp
+
+import (
+	"net"
+
+	"github.com/sasakihasuto/tinytcp/internal/link"
+)
+
+// NewTUNStack creates a stack on the Linux TUN interface name (created if
+// it does not exist) with addr as its local address. It requires
+// CAP_NET_ADMIN; the interface must be configured and brought up separately.
+func NewTUNStack(name string, addr net.IP) (*Stack, error) {
+	ep, err := link.NewTUNEndpoint(name, defaultMTU)
+	if err != nil {
+		return nil, err
+	}
+	return newStack(ep, addr), nil
+}